@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/fawadmazhar/nats-pubsub/internal/config"
+	"github.com/fawadmazhar/nats-pubsub/internal/logging"
 	"github.com/fawadmazhar/nats-pubsub/internal/monitor"
 )
 
@@ -20,12 +21,19 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Create monitor service
-	monitorService := monitor.NewMonitor(cfg.NatsMonitorURL)
+	monitorService := monitor.NewMonitor(cfg.Nats.MonitorURL, logger)
 
 	// Handle graceful shutdown
 	sigCh := make(chan os.Signal, 1)
@@ -35,19 +43,19 @@ func main() {
 	go func() {
 		// Default to checking every 5 seconds
 		if err := monitorService.Run(ctx, 5*time.Second); err != nil {
-			log.Printf("Monitor error: %v", err)
+			logger.Error("Monitor error", "error", err)
 			cancel()
 		}
 	}()
 
-	fmt.Println("NATS monitor started. Press Ctrl+C to exit.")
+	logger.Info("NATS monitor started, press Ctrl+C to exit")
 
 	// Wait for termination signal
 	<-sigCh
-	fmt.Println("\nShutting down monitor...")
+	logger.Info("Shutting down monitor")
 	cancel()
-	
+
 	// Give a moment for any in-flight operations to complete
 	time.Sleep(500 * time.Millisecond)
-	fmt.Println("Monitor shutdown complete")
+	logger.Info("Monitor shutdown complete")
 }
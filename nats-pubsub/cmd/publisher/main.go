@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/fawadmazhar/nats-pubsub/internal/codec"
+	"github.com/fawadmazhar/nats-pubsub/internal/compress"
 	"github.com/fawadmazhar/nats-pubsub/internal/config"
+	"github.com/fawadmazhar/nats-pubsub/internal/envelope"
+	"github.com/fawadmazhar/nats-pubsub/internal/health"
+	"github.com/fawadmazhar/nats-pubsub/internal/logging"
 	"github.com/fawadmazhar/nats-pubsub/internal/pubsub"
+	"github.com/fawadmazhar/nats-pubsub/internal/schema"
 	"github.com/fawadmazhar/nats-pubsub/internal/stream"
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -21,25 +31,157 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Connect to NATS and setup JetStream
-	js, nc, err := stream.Connect(cfg.NatsURL)
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(cfg.Log.Level, cfg.Log.Format)
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		log.Fatalf("Failed to create logger: %v", err)
 	}
-	defer nc.Close()
+	slog.SetDefault(logger)
 
-	// Create the stream
-	err = stream.Setup(js, cfg.Stream)
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, "nats-pubsub-publisher")
 	if err != nil {
-		log.Fatalf("Failed to setup stream: %v", err)
+		logger.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
 	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("Error flushing traces", "error", err)
+		}
+	}()
+
+	// Connect to NATS and setup JetStream
+	conn, err := stream.Connect(cfg.Nats, logger)
+	if err != nil {
+		logger.Error("Failed to connect to NATS", "error", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Create publisher
-	publisher := pubsub.NewPublisher(js, cfg.Stream.SubjectName)
+	// Create the stream
+	err = stream.Setup(ctx, conn.JS, cfg.Stream)
+	if err != nil {
+		logger.Error("Failed to setup stream", "error", err)
+		os.Exit(1)
+	}
+
+	// Offload large payloads to the object store if configured
+	objects, err := stream.SetupObjectStore(ctx, conn.JS, cfg.ClaimCheck)
+	if err != nil {
+		logger.Error("Failed to setup object store", "error", err)
+		os.Exit(1)
+	}
+
+	// Load the encryption keyring if configured
+	keyring, err := envelope.Setup(cfg.Encryption)
+	if err != nil {
+		logger.Error("Failed to load encryption keys", "error", err)
+		os.Exit(1)
+	}
+
+	// Validate payloads against the schema registry if configured
+	schemas, err := schema.Setup(ctx, conn.JS, cfg.Schema)
+	if err != nil {
+		logger.Error("Failed to setup schema registry", "error", err)
+		os.Exit(1)
+	}
+	schemaMode, err := schema.ParseMode(cfg.Schema.Mode)
+	if err != nil {
+		logger.Error("Invalid schema mode", "error", err)
+		os.Exit(1)
+	}
+
+	// Register the publisher's schema, which fails if it is incompatible
+	if cfg.Schema.File != "" {
+		if schemas == nil {
+			logger.Error("Schema file set without a schema bucket")
+			os.Exit(1)
+		}
+		raw, err := os.ReadFile(cfg.Schema.File)
+		if err != nil {
+			logger.Error("Failed to read schema", "error", err)
+			os.Exit(1)
+		}
+		version, err := schemas.Register(ctx, cfg.Stream.SubjectName, raw)
+		if err != nil {
+			logger.Error("Failed to register schema", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Schema registered", "subject", cfg.Stream.SubjectName, "schema_version", version)
+	}
+
+	// Record client-side metrics in a registry served on /metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metrics := pubsub.NewMetrics(registry)
+
+	// Create publisher, encoding messages with the configured codec
+	c, err := codec.ByName(cfg.Publisher.Codec)
+	if err != nil {
+		logger.Error("Invalid codec", "error", err)
+		os.Exit(1)
+	}
+	if c == codec.Protobuf {
+		// The published messages are plain structs, not proto.Message
+		logger.Error("The protobuf codec is not supported by the publisher, use json, msgpack or cbor")
+		os.Exit(1)
+	}
+	opts := []pubsub.PublisherOption{
+		pubsub.WithLogger(logger),
+		pubsub.WithMetrics(metrics),
+		pubsub.WithCodec(c),
+	}
+	if schemas != nil {
+		opts = append(opts, pubsub.WithSchemas(schemas, schemaMode))
+	}
+	if keyring != nil {
+		opts = append(opts, pubsub.WithEncryption(keyring, cfg.Encryption.Subjects...))
+	}
+	if objects != nil {
+		opts = append(opts, pubsub.WithClaimCheck(objects, cfg.ClaimCheck.Threshold))
+	}
+	if cfg.Publisher.Compression != "" {
+		compressor, err := compress.ByName(cfg.Publisher.Compression)
+		if err != nil {
+			logger.Error("Invalid compression", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, pubsub.WithCompression(compressor, cfg.Publisher.CompressionThreshold))
+	}
+	publisher := pubsub.NewPublisher(conn.JS, cfg.Stream.SubjectName, opts...)
+
+	// Serve health endpoints if configured
+	if cfg.Health.Addr != "" {
+		healthServer := health.NewServer(cfg.Health.Addr, logger)
+		healthServer.AddCheck("nats", func(ctx context.Context) error {
+			return conn.Healthy()
+		})
+		healthServer.AddCheck("stream", func(ctx context.Context) error {
+			return stream.Exists(ctx, conn.JS, cfg.Stream.Name)
+		})
+		healthServer.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		healthServer.SetStatus(func() any {
+			return map[string]any{
+				"config":     cfg.Summary(),
+				"connection": conn.Stats(),
+				"publisher":  publisher.Stats(),
+			}
+		})
+		go func() {
+			if err := healthServer.Run(ctx); err != nil {
+				logger.Error("Health server error", "error", err)
+			}
+		}()
+	}
 
 	// Handle graceful shutdown
 	sigCh := make(chan os.Signal, 1)
@@ -48,17 +190,21 @@ func main() {
 	// Start publishing in a goroutine
 	go func() {
 		if err := publisher.Run(ctx, 2*time.Second); err != nil {
-			log.Printf("Publisher error: %v", err)
+			logger.Error("Publisher error", "error", err)
 			cancel()
 		}
 	}()
 
-	// Wait for termination signal
-	<-sigCh
-	fmt.Println("\nShutting down publisher...")
+	// Wait for termination signal or for the connection to be closed for good
+	select {
+	case <-sigCh:
+	case <-conn.Closed():
+		logger.Info("NATS connection closed, exiting")
+	}
+	logger.Info("Shutting down publisher")
 	cancel()
-	
+
 	// Give a moment for any in-flight operations to complete
 	time.Sleep(500 * time.Millisecond)
-	fmt.Println("Publisher shutdown complete")
+	logger.Info("Publisher shutdown complete")
 }
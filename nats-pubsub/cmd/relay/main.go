@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/compress"
+	"github.com/fawadmazhar/nats-pubsub/internal/config"
+	"github.com/fawadmazhar/nats-pubsub/internal/envelope"
+	"github.com/fawadmazhar/nats-pubsub/internal/health"
+	"github.com/fawadmazhar/nats-pubsub/internal/logging"
+	"github.com/fawadmazhar/nats-pubsub/internal/outbox"
+	"github.com/fawadmazhar/nats-pubsub/internal/pubsub"
+	"github.com/fawadmazhar/nats-pubsub/internal/schema"
+	"github.com/fawadmazhar/nats-pubsub/internal/stream"
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, "nats-pubsub-relay")
+	if err != nil {
+		logger.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("Error flushing traces", "error", err)
+		}
+	}()
+
+	// Create context for graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Open the outbox database, creating the table if needed
+	db, err := outbox.Open(ctx, cfg.Outbox.DB)
+	if err != nil {
+		logger.Error("Failed to open outbox", "error", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	// Connect to NATS and setup JetStream
+	conn, err := stream.Connect(cfg.Nats, logger)
+	if err != nil {
+		logger.Error("Failed to connect to NATS", "error", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	// Create the stream
+	err = stream.Setup(ctx, conn.JS, cfg.Stream)
+	if err != nil {
+		logger.Error("Failed to setup stream", "error", err)
+		os.Exit(1)
+	}
+
+	// Offload large payloads to the object store if configured
+	objects, err := stream.SetupObjectStore(ctx, conn.JS, cfg.ClaimCheck)
+	if err != nil {
+		logger.Error("Failed to setup object store", "error", err)
+		os.Exit(1)
+	}
+
+	// Load the encryption keyring if configured
+	keyring, err := envelope.Setup(cfg.Encryption)
+	if err != nil {
+		logger.Error("Failed to load encryption keys", "error", err)
+		os.Exit(1)
+	}
+
+	// Validate payloads against the schema registry if configured
+	schemas, err := schema.Setup(ctx, conn.JS, cfg.Schema)
+	if err != nil {
+		logger.Error("Failed to setup schema registry", "error", err)
+		os.Exit(1)
+	}
+	schemaMode, err := schema.ParseMode(cfg.Schema.Mode)
+	if err != nil {
+		logger.Error("Invalid schema mode", "error", err)
+		os.Exit(1)
+	}
+
+	// Record client-side metrics in a registry served on /metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metrics := pubsub.NewMetrics(registry)
+
+	// Create the publisher relaying events, which are published as stored
+	opts := []pubsub.PublisherOption{
+		pubsub.WithLogger(logger),
+		pubsub.WithMetrics(metrics),
+	}
+	if schemas != nil {
+		opts = append(opts, pubsub.WithSchemas(schemas, schemaMode))
+	}
+	if keyring != nil {
+		opts = append(opts, pubsub.WithEncryption(keyring, cfg.Encryption.Subjects...))
+	}
+	if objects != nil {
+		opts = append(opts, pubsub.WithClaimCheck(objects, cfg.ClaimCheck.Threshold))
+	}
+	if cfg.Publisher.Compression != "" {
+		compressor, err := compress.ByName(cfg.Publisher.Compression)
+		if err != nil {
+			logger.Error("Invalid compression", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, pubsub.WithCompression(compressor, cfg.Publisher.CompressionThreshold))
+	}
+	publisher := pubsub.NewPublisher(conn.JS, cfg.Stream.SubjectName, opts...)
+
+	relay := outbox.NewRelay(db, publisher,
+		outbox.WithLogger(logger),
+		outbox.WithBatchSize(cfg.Outbox.BatchSize),
+		outbox.WithPollInterval(cfg.Outbox.PollInterval),
+		outbox.WithRetention(cfg.Outbox.Retention),
+		outbox.WithIDPrefix(cfg.Outbox.IDPrefix),
+	)
+
+	// Serve health endpoints if configured
+	if cfg.Health.Addr != "" {
+		healthServer := health.NewServer(cfg.Health.Addr, logger)
+		healthServer.AddCheck("nats", func(ctx context.Context) error {
+			return conn.Healthy()
+		})
+		healthServer.AddCheck("stream", func(ctx context.Context) error {
+			return stream.Exists(ctx, conn.JS, cfg.Stream.Name)
+		})
+		healthServer.AddCheck("outbox", func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		})
+		healthServer.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		healthServer.SetStatus(func() any {
+			return map[string]any{
+				"config":     cfg.Summary(),
+				"connection": conn.Stats(),
+				"publisher":  publisher.Stats(),
+				"relay":      relay.Stats(),
+			}
+		})
+		go func() {
+			if err := healthServer.Run(ctx); err != nil {
+				logger.Error("Health server error", "error", err)
+			}
+		}()
+	}
+
+	// Handle graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	// Start relaying in a goroutine
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		if err := relay.Run(ctx); err != nil {
+			logger.Error("Relay error", "error", err)
+		}
+	}()
+
+	// Wait for termination signal, for the connection to be closed for good or
+	// for the relay to stop
+	select {
+	case <-sigCh:
+	case <-conn.Closed():
+		logger.Info("NATS connection closed, exiting")
+	case <-doneCh:
+	}
+	logger.Info("Shutting down relay")
+	cancel()
+
+	// Let the event being published finish before the database is closed
+	<-doneCh
+	logger.Info("Relay shutdown complete")
+}
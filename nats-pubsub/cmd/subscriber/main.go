@@ -2,37 +2,286 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fawadmazhar/nats-pubsub/internal/config"
+	"github.com/fawadmazhar/nats-pubsub/internal/envelope"
+	"github.com/fawadmazhar/nats-pubsub/internal/health"
+	"github.com/fawadmazhar/nats-pubsub/internal/logging"
 	"github.com/fawadmazhar/nats-pubsub/internal/pubsub"
+	"github.com/fawadmazhar/nats-pubsub/internal/schema"
 	"github.com/fawadmazhar/nats-pubsub/internal/stream"
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	// Parse replay flags, replaying through an ephemeral consumer if any is set
+	var replay pubsub.Replay
+	flag.Uint64Var(&replay.StartSeq, "replay-start-seq", 0, "replay from this stream sequence")
+	flag.Func("replay-start-time", "replay messages stored since this RFC 3339 time", func(v string) (err error) {
+		replay.StartTime, err = time.Parse(time.RFC3339, v)
+		return err
+	})
+	flag.Uint64Var(&replay.LastN, "replay-last", 0, "replay the last N messages on the subscribed subjects")
+	flag.BoolVar(&replay.LastPerSubject, "replay-last-per-subject", false, "replay the last message of every subject")
+	flag.Uint64Var(&replay.EndSeq, "replay-end-seq", 0, "stop the replay after this stream sequence")
+	flag.Func("replay-end-time", "stop the replay after messages stored at this RFC 3339 time", func(v string) (err error) {
+		replay.EndTime, err = time.Parse(time.RFC3339, v)
+		return err
+	})
+	flag.Parse()
+
+	replaying := false
+	flag.Visit(func(f *flag.Flag) {
+		replaying = replaying || strings.HasPrefix(f.Name, "replay-")
+	})
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, "nats-pubsub-subscriber")
+	if err != nil {
+		logger.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("Error flushing traces", "error", err)
+		}
+	}()
+
 	// Connect to NATS
-	js, nc, err := stream.Connect(cfg.NatsURL)
+	conn, err := stream.Connect(cfg.Nats, logger)
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		logger.Error("Failed to connect to NATS", "error", err)
+		os.Exit(1)
 	}
-	defer nc.Close()
+	defer conn.Close()
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Record client-side metrics in a registry served on /metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metrics := pubsub.NewMetrics(registry)
+
+	// Fetch claim-checked payloads from the object store if configured
+	objects, err := stream.SetupObjectStore(ctx, conn.JS, cfg.ClaimCheck)
+	if err != nil {
+		logger.Error("Failed to setup object store", "error", err)
+		os.Exit(1)
+	}
+
+	// Load the encryption keyring if configured
+	keyring, err := envelope.Setup(cfg.Encryption)
+	if err != nil {
+		logger.Error("Failed to load encryption keys", "error", err)
+		os.Exit(1)
+	}
+
+	// Validate payloads against the schema registry if configured
+	schemas, err := schema.Setup(ctx, conn.JS, cfg.Schema)
+	if err != nil {
+		logger.Error("Failed to setup schema registry", "error", err)
+		os.Exit(1)
+	}
+	schemaMode, err := schema.ParseMode(cfg.Schema.Mode)
+	if err != nil {
+		logger.Error("Invalid schema mode", "error", err)
+		os.Exit(1)
+	}
+
+	// Skip already processed messages if configured
+	var idempotency *pubsub.Idempotency
+	if cfg.Idempotency.Bucket != "" {
+		// Batch handlers bypass the middleware, which would silently disable
+		// deduplication
+		if cfg.Subscriber.BatchSize > 0 {
+			logger.Error("Idempotency is not supported with batch handlers",
+				"bucket", cfg.Idempotency.Bucket, "batch_size", cfg.Subscriber.BatchSize)
+			os.Exit(1)
+		}
+		idFunc, err := pubsub.ParseKeyFunc(cfg.Idempotency.Key)
+		if err != nil {
+			logger.Error("Invalid idempotency key", "error", err)
+			os.Exit(1)
+		}
+		idempotency, err = pubsub.NewIdempotency(ctx, conn.JS, cfg.Idempotency.Bucket, cfg.Idempotency.TTL, idFunc)
+		if err != nil {
+			logger.Error("Failed to setup idempotency store", "error", err)
+			os.Exit(1)
+		}
+		idempotency.SetClaimTimeout(cfg.Idempotency.ClaimTimeout)
+	}
+
 	// Create subscriber
-	subscriber := pubsub.NewSubscriber(js, cfg.Stream.Name, cfg.Stream.SubjectName)
+	opts := []pubsub.SubscriberOption{
+		pubsub.WithConnectionState(conn),
+		pubsub.WithFilterSubjects(cfg.Subscriber.FilterSubjects...),
+		pubsub.WithGracePeriod(cfg.Subscriber.GracePeriod),
+		pubsub.WithFetchMaxWait(cfg.Subscriber.FetchMaxWait),
+		pubsub.WithFetchMaxBytes(cfg.Subscriber.FetchMaxBytes),
+		pubsub.WithFetchHeartbeat(cfg.Subscriber.FetchHeartbeat),
+		pubsub.WithLogger(logger),
+		pubsub.WithMetrics(metrics),
+	}
+	if schemas != nil {
+		opts = append(opts, pubsub.WithSchemas(schemas, schemaMode))
+	}
+	if keyring != nil {
+		opts = append(opts, pubsub.WithEncryption(keyring, cfg.Encryption.Subjects...))
+	}
+	if objects != nil {
+		opts = append(opts, pubsub.WithClaimCheck(objects, cfg.ClaimCheck.Threshold))
+		if cfg.ClaimCheck.Delete {
+			opts = append(opts, pubsub.WithClaimCheckDelete())
+		}
+	}
+
+	// Wrap the handler with the built-in middleware, deduplicating inside the
+	// timeout so that a timed out handler releases its claim
+	stack := pubsub.Stack{
+		Tracing:  true,
+		Logging:  true,
+		LogLevel: slog.LevelDebug,
+		Metrics:  metrics,
+		Timeout:  cfg.Handler.Timeout,
+	}
+	if idempotency != nil {
+		stack.Middleware = append(stack.Middleware, idempotency.Middleware)
+	}
+	opts = append(opts, pubsub.WithMiddleware(stack.Build()...))
+
+	// Keys are extracted before payloads are fetched from the object store and
+	// decrypted, so JSON keys would read the claim check or the ciphertext
+	sealed := keyring != nil || objects != nil
+
+	if cfg.RateLimit.Rate > 0 || cfg.RateLimit.PerKeyRate > 0 {
+		if sealed && strings.HasPrefix(cfg.RateLimit.Key, "json:") {
+			logger.Error("JSON rate limit keys are not supported with encryption or claim checks", "key", cfg.RateLimit.Key)
+			os.Exit(1)
+		}
+		rateKey, err := pubsub.ParseKeyFunc(cfg.RateLimit.Key)
+		if err != nil {
+			logger.Error("Invalid rate limit key", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, pubsub.WithRateLimit(pubsub.RateLimitConfig{
+			Rate:        cfg.RateLimit.Rate,
+			Burst:       cfg.RateLimit.Burst,
+			PerKeyRate:  cfg.RateLimit.PerKeyRate,
+			PerKeyBurst: cfg.RateLimit.PerKeyBurst,
+			Key:         rateKey,
+		}))
+	}
+	if cfg.Breaker.FailureRate > 0 {
+		opts = append(opts, pubsub.WithCircuitBreaker(pubsub.BreakerConfig{
+			FailureRate: cfg.Breaker.FailureRate,
+			Window:      cfg.Breaker.Window,
+			MinCalls:    cfg.Breaker.MinCalls,
+			OpenTimeout: cfg.Breaker.OpenTimeout,
+			Probes:      cfg.Breaker.Probes,
+		}))
+	}
+	switch cfg.Subscriber.Mode {
+	case "pull":
+	case "push":
+		opts = append(opts, pubsub.WithPush(cfg.Subscriber.DeliverGroup))
+	case "ordered":
+		opts = append(opts, pubsub.WithOrdered())
+	default:
+		logger.Error("Unknown subscriber mode", "mode", cfg.Subscriber.Mode)
+		os.Exit(1)
+	}
+	if cfg.Subscriber.Key != "" {
+		if sealed && strings.HasPrefix(cfg.Subscriber.Key, "json:") {
+			logger.Error("JSON subscriber keys are not supported with encryption or claim checks", "key", cfg.Subscriber.Key)
+			os.Exit(1)
+		}
+		keyFunc, err := pubsub.ParseKeyFunc(cfg.Subscriber.Key)
+		if err != nil {
+			logger.Error("Invalid subscriber key", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, pubsub.WithKeyFunc(keyFunc))
+	}
+	if cfg.Subscriber.BatchSize > 0 {
+		opts = append(opts, pubsub.WithBatchHandler(pubsub.LogBatch, cfg.Subscriber.BatchSize, cfg.Subscriber.BatchWait))
+	}
+	if replaying {
+		logger.Info("Replaying messages", "start_seq", replay.StartSeq, "start_time", replay.StartTime,
+			"last", replay.LastN, "last_per_subject", replay.LastPerSubject,
+			"end_seq", replay.EndSeq, "end_time", replay.EndTime)
+		opts = append(opts, pubsub.WithReplay(replay))
+	}
+	subscriber := pubsub.NewSubscriber(conn.JS, cfg.Stream.Name, cfg.Stream.SubjectName, opts...)
+
+	// Serve health endpoints if configured
+	if cfg.Health.Addr != "" {
+		healthServer := health.NewServer(cfg.Health.Addr, logger)
+		healthServer.AddCheck("nats", func(ctx context.Context) error {
+			return conn.Healthy()
+		})
+		healthServer.AddCheck("stream", func(ctx context.Context) error {
+			return stream.Exists(ctx, conn.JS, cfg.Stream.Name)
+		})
+		healthServer.AddCheck("consumer", func(ctx context.Context) error {
+			if !subscriber.Bound() {
+				return errors.New("consumer not bound")
+			}
+			return nil
+		})
+		if cfg.Breaker.FailureRate > 0 {
+			healthServer.AddCheck("circuit_breaker", func(ctx context.Context) error {
+				if subscriber.BreakerState() == pubsub.BreakerOpen {
+					return errors.New("circuit breaker open")
+				}
+				return nil
+			})
+		}
+		healthServer.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		healthServer.SetStatus(func() any {
+			return map[string]any{
+				"config":     cfg.Summary(),
+				"connection": conn.Stats(),
+				"subscriber": subscriber.Stats(),
+			}
+		})
+		go func() {
+			if err := healthServer.Run(ctx); err != nil {
+				logger.Error("Health server error", "error", err)
+			}
+		}()
+	}
 
 	// Handle graceful shutdown
 	sigCh := make(chan os.Signal, 1)
@@ -42,18 +291,23 @@ func main() {
 	doneCh := make(chan struct{})
 	go func() {
 		defer close(doneCh)
-		if err := subscriber.Run(ctx, 10); err != nil { // Max 10 concurrent messages
-			log.Printf("Subscriber error: %v", err)
+		if err := subscriber.Run(ctx, cfg.Subscriber.Workers); err != nil {
+			logger.Error("Subscriber error", "error", err)
 			cancel()
 		}
 	}()
 
-	// Wait for termination signal
-	<-sigCh
-	fmt.Println("\nShutting down subscriber...")
+	// Wait for termination signal or for the subscriber to stop on its own,
+	// such as when a replay reaches its end bound
+	select {
+	case <-sigCh:
+	case <-ctx.Done():
+	case <-doneCh:
+	}
+	logger.Info("Shutting down subscriber")
 	cancel()
-	
+
 	// Wait for subscriber to finish processing
 	<-doneCh
-	fmt.Println("Subscriber shutdown complete")
+	logger.Info("Subscriber shutdown complete")
 }
@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeHeader is the message header carrying the content type of the payload
+const ContentTypeHeader = "Content-Type"
+
+// Codec encodes and decodes message payloads of one content type
+type Codec interface {
+	// ContentType returns the MIME type stamped on encoded messages
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Built-in codecs. Struct fields are named by their json tags in every format
+// but Protocol Buffers, which only encodes generated proto.Message types.
+var (
+	JSON     Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	CBOR     Codec = cborCodec{}
+)
+
+var (
+	byName        = map[string]Codec{"json": JSON, "protobuf": Protobuf, "msgpack": MsgPack, "cbor": CBOR}
+	byContentType = map[string]Codec{}
+)
+
+func init() {
+	for _, c := range byName {
+		byContentType[c.ContentType()] = c
+	}
+}
+
+// ByName returns the codec called json, protobuf, msgpack or cbor
+func ByName(name string) (Codec, error) {
+	c, ok := byName[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// ForContentType returns the codec for a content type header value. Messages
+// without a content type are assumed to be JSON.
+func ForContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	// Ignore parameters such as charset
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	c, ok := byContentType[strings.TrimSpace(strings.ToLower(mediaType))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return "application/protobuf" }
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot encode %T, not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec cannot decode into %T, not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// CBOR encodes times as RFC 3339 strings and decodes maps with string keys, so
+// payloads map onto the JSON data model like the other codecs
+var (
+	cborEnc, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	cborDec, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any(nil))}.DecMode()
+)
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string { return "application/cbor" }
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return cborEnc.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cborDec.Unmarshal(data, v)
+}
@@ -0,0 +1,124 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// EncodingHeader is the message header naming the compression of the payload
+const EncodingHeader = "Content-Encoding"
+
+// MaxDecodedSize bounds the size of a decompressed payload, protecting
+// subscribers from decompression bombs
+const MaxDecodedSize = 64 * 1024 * 1024
+
+// ErrTooLarge is returned for payloads decompressing beyond MaxDecodedSize
+var ErrTooLarge = errors.New("decompressed payload too large")
+
+// Compressor compresses and decompresses payloads with one algorithm
+type Compressor interface {
+	// Encoding returns the name stamped in the encoding header
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Built-in compressors. Gzip is the most widely supported, zstd compresses
+// best and s2 is the fastest.
+var (
+	Gzip Compressor = gzipCompressor{}
+	Zstd Compressor = zstdCompressor{}
+	S2   Compressor = s2Compressor{}
+)
+
+var byEncoding = map[string]Compressor{"gzip": Gzip, "zstd": Zstd, "s2": S2}
+
+// ByName returns the compressor called gzip, zstd or s2
+func ByName(name string) (Compressor, error) {
+	c, ok := byEncoding[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q", name)
+	}
+	return c, nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Encoding() string { return "gzip" }
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, MaxDecodedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxDecodedSize {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and DecodeAll calls
+var (
+	zstdEnc, _ = zstd.NewWriter(nil)
+	zstdDec, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecodedSize))
+)
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Encoding() string { return "zstd" }
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return zstdEnc.EncodeAll(data, nil), nil
+}
+
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	out, err := zstdDec.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, ErrTooLarge
+	}
+	return out, err
+}
+
+type s2Compressor struct{}
+
+func (s2Compressor) Encoding() string { return "s2" }
+
+func (s2Compressor) Compress(data []byte) ([]byte, error) {
+	return s2.Encode(nil, data), nil
+}
+
+func (s2Compressor) Decompress(data []byte) ([]byte, error) {
+	n, err := s2.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxDecodedSize {
+		return nil, ErrTooLarge
+	}
+	return s2.Decode(nil, data)
+}
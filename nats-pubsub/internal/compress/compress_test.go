@@ -0,0 +1,74 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var compressors = []Compressor{Gzip, Zstd, S2}
+
+func TestRoundTrip(t *testing.T) {
+	payloads := map[string][]byte{
+		"empty":      {},
+		"text":       []byte(`{"id":"o-1","items":["a","b","c"]}`),
+		"repetitive": bytes.Repeat([]byte("order "), 10000),
+	}
+	for _, c := range compressors {
+		for name, data := range payloads {
+			t.Run(c.Encoding()+"/"+name, func(t *testing.T) {
+				compressed, err := c.Compress(data)
+				if err != nil {
+					t.Fatalf("Compress error = %v", err)
+				}
+				got, err := c.Decompress(compressed)
+				if err != nil {
+					t.Fatalf("Decompress error = %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("Decompress = %d bytes, want the original %d bytes", len(got), len(data))
+				}
+			})
+		}
+	}
+}
+
+func TestDecompressTooLarge(t *testing.T) {
+	data := make([]byte, MaxDecodedSize+1)
+	for _, c := range compressors {
+		t.Run(c.Encoding(), func(t *testing.T) {
+			compressed, err := c.Compress(data)
+			if err != nil {
+				t.Fatalf("Compress error = %v", err)
+			}
+			if _, err := c.Decompress(compressed); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("Decompress error = %v, want %v", err, ErrTooLarge)
+			}
+		})
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	for _, c := range compressors {
+		t.Run(c.Encoding(), func(t *testing.T) {
+			if _, err := c.Decompress([]byte("not compressed")); err == nil {
+				t.Error("Decompress succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestByName(t *testing.T) {
+	for _, c := range compressors {
+		got, err := ByName(c.Encoding())
+		if err != nil || got != c {
+			t.Errorf("ByName(%q) = %v, %v, want %v", c.Encoding(), got, err, c)
+		}
+	}
+	if got, err := ByName("GZIP"); err != nil || got != Gzip {
+		t.Errorf("ByName(%q) = %v, %v, want %v", "GZIP", got, err, Gzip)
+	}
+	if _, err := ByName("brotli"); err == nil {
+		t.Errorf("ByName(%q) succeeded, want an error", "brotli")
+	}
+}
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -13,25 +17,279 @@ type StreamConfig struct {
 	MaxAge      int64 // in seconds
 }
 
+// TLSConfig holds the TLS settings used when connecting to NATS
+type TLSConfig struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// Enabled reports whether any TLS setting has been configured
+func (t TLSConfig) Enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != ""
+}
+
+// AuthConfig holds the credentials used when connecting to NATS
+type AuthConfig struct {
+	User      string
+	Password  string
+	Token     string
+	NKeyFile  string // NKey seed file
+	CredsFile string // JWT .creds file
+}
+
+// ReconnectConfig holds the NATS reconnection policy
+type ReconnectConfig struct {
+	MaxReconnects int // -1 retries forever
+	Wait          time.Duration
+	Jitter        time.Duration
+	JitterTLS     time.Duration
+	BufSize       int // bytes buffered while reconnecting, -1 disables buffering
+}
+
+// NatsConfig holds the NATS connection settings
+type NatsConfig struct {
+	URL        string
+	MonitorURL string
+	TLS        TLSConfig
+	Auth       AuthConfig
+	Reconnect  ReconnectConfig
+}
+
+// PublisherConfig holds the message publishing settings
+type PublisherConfig struct {
+	Codec                string // json, msgpack or cbor, protobuf only applies to library users
+	Compression          string // gzip, zstd or s2, disabled when empty
+	CompressionThreshold int    // payload size in bytes from which to compress
+}
+
+// SubscriberConfig holds the message consumption settings
+type SubscriberConfig struct {
+	Mode           string        // pull, push or ordered
+	DeliverGroup   string        // deliver group of the push consumer, the consumer name when empty
+	FilterSubjects []string      // subjects to consume, stream.subjectName when empty
+	Workers        int           // concurrent message handlers
+	Key            string        // ordering key spec, subject:<index>, header:<name> or json:<path>
+	BatchSize      int           // messages per handler call, 0 handles them one at a time
+	BatchWait      time.Duration // how long a batch may wait to fill up
+	GracePeriod    time.Duration // time in-flight handlers get to finish on shutdown
+	FetchMaxWait   time.Duration // how long a fetch waits for messages
+	FetchMaxBytes  int           // payload size limit of a fetch, 0 disables it
+	FetchHeartbeat time.Duration // idle heartbeat of a fetch, 0 disables it
+}
+
+// HandlerConfig holds the settings of the middleware wrapping the message handler
+type HandlerConfig struct {
+	Timeout time.Duration // how long a handler may run, 0 disables the timeout
+}
+
+// RateLimitConfig holds the settings throttling the dispatch of messages to the workers
+type RateLimitConfig struct {
+	Rate        float64 // messages per second across the subscriber, 0 disables the limit
+	Burst       int     // messages dispatched at once above the rate
+	PerKeyRate  float64 // messages per second for each key, 0 disables per-key limits
+	PerKeyBurst int     // messages of a key dispatched at once above its rate
+	Key         string  // key spec of the per-key limits, subject, subject:<index>, header:<name> or json:<path>
+}
+
+// CircuitBreakerConfig holds the settings of the subscriber's circuit breaker
+type CircuitBreakerConfig struct {
+	FailureRate float64       // share of failed handler calls opening the breaker, 0 disables it
+	Window      int           // recent handler calls the failure rate is computed over
+	MinCalls    int           // handler calls needed before the breaker can open
+	OpenTimeout time.Duration // how long the breaker stays open before probing
+	Probes      int           // messages let through while half-open
+}
+
+// SchemaConfig holds the schema registry settings
+type SchemaConfig struct {
+	Bucket        string // KV bucket holding the schemas, disabled when empty
+	Mode          string // reject or warn on invalid payloads
+	Compatibility string // none, backward, forward or full
+	File          string // schema the publisher registers for its subject on startup
+}
+
+// EncryptionConfig holds the payload encryption settings
+type EncryptionConfig struct {
+	KeysFile   string   // JSON keyring file, takes precedence over Keys
+	Keys       string   // key-encryption keys as <id>:<base64 key>,...
+	CurrentKey string   // key sealing new payloads, the last of Keys when empty
+	Subjects   []string // subjects to encrypt, may contain wildcards
+}
+
+// ClaimCheckConfig holds the settings for offloading large payloads to an object store
+type ClaimCheckConfig struct {
+	Bucket    string        // object store bucket, disabled when empty
+	Threshold int           // payload size in bytes from which to offload, 0 for the server's max payload
+	TTL       time.Duration // how long stored payloads are kept, 0 keeps them forever
+	Delete    bool          // delete the payload once the subscriber acks its message
+}
+
+// IdempotencyConfig holds the settings for skipping already processed messages
+type IdempotencyConfig struct {
+	Bucket       string        // KV bucket of processed message IDs, disabled when empty
+	TTL          time.Duration // how long processed IDs are remembered
+	Key          string        // message ID spec, header:<name> or json:<path>
+	ClaimTimeout time.Duration // how long a worker processing a message blocks duplicates
+}
+
+// OutboxConfig holds the outbox relay settings
+type OutboxConfig struct {
+	DB           string        // SQLite database file
+	BatchSize    int           // events read from the table at once
+	PollInterval time.Duration // how often the table is polled once empty
+	Retention    time.Duration // how long sent events are kept, 0 keeps them forever
+	IDPrefix     string        // prefix of message IDs, unique per outbox
+}
+
+// HealthConfig holds the settings of the optional health HTTP server
+type HealthConfig struct {
+	Addr string // disabled when empty
+}
+
+// TracingConfig holds the OpenTelemetry tracing settings
+type TracingConfig struct {
+	Exporter string // none, stdout or otlp
+}
+
+// LogConfig holds the logging settings
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+}
+
 type Config struct {
-	NatsURL        string
-	NatsMonitorURL string
-	Stream         StreamConfig
+	Nats        NatsConfig
+	Stream      StreamConfig
+	Publisher   PublisherConfig
+	Subscriber  SubscriberConfig
+	Handler     HandlerConfig
+	RateLimit   RateLimitConfig
+	Breaker     CircuitBreakerConfig
+	Schema      SchemaConfig
+	Encryption  EncryptionConfig
+	ClaimCheck  ClaimCheckConfig
+	Idempotency IdempotencyConfig
+	Outbox      OutboxConfig
+	Health      HealthConfig
+	Tracing     TracingConfig
+	Log         LogConfig
+}
+
+// Summary holds the non-secret settings reported on status endpoints
+type Summary struct {
+	NatsURL     string   `json:"nats_url"`
+	TLS         bool     `json:"tls"`
+	Auth        string   `json:"auth"`
+	Stream      string   `json:"stream"`
+	Subjects    []string `json:"subjects"`
+	SubjectName string   `json:"subject_name"`
+	Retention   string   `json:"retention"`
+	Storage     string   `json:"storage"`
+}
+
+// Summary returns the configuration with credentials left out
+func (c *Config) Summary() Summary {
+	natsURL := c.Nats.URL
+	if u, err := url.Parse(natsURL); err == nil {
+		natsURL = u.Redacted()
+	}
+
+	auth := "none"
+	switch {
+	case c.Nats.Auth.User != "":
+		auth = "user"
+	case c.Nats.Auth.Token != "":
+		auth = "token"
+	case c.Nats.Auth.NKeyFile != "":
+		auth = "nkey"
+	case c.Nats.Auth.CredsFile != "":
+		auth = "creds"
+	}
+
+	return Summary{
+		NatsURL:     natsURL,
+		TLS:         c.Nats.TLS.Enabled(),
+		Auth:        auth,
+		Stream:      c.Stream.Name,
+		Subjects:    c.Stream.Subjects,
+		SubjectName: c.Stream.SubjectName,
+		Retention:   c.Stream.Retention,
+		Storage:     c.Stream.Storage,
+	}
 }
 
 func Load() (*Config, error) {
 	// Set default values
 	viper.SetDefault("nats.url", "nats://localhost:4222")
 	viper.SetDefault("nats.monitor_url", "http://localhost:8222")
+	viper.SetDefault("nats.reconnect.max", -1) // retry forever
+	viper.SetDefault("nats.reconnect.wait", time.Second)
+	viper.SetDefault("nats.reconnect.jitter", 100*time.Millisecond)
+	viper.SetDefault("nats.reconnect.jitter_tls", time.Second)
+	viper.SetDefault("nats.reconnect.buf_size", 8*1024*1024) // 8MB
 	viper.SetDefault("stream.name", "ORDERS")
 	viper.SetDefault("stream.subjects", []string{"ORDERS.*"})
 	viper.SetDefault("stream.subjectName", "ORDERS.received")
 	viper.SetDefault("stream.retention", "workqueue")
 	viper.SetDefault("stream.storage", "file")
 	viper.SetDefault("stream.maxAge", 86400) // 24 hours in seconds
+	viper.SetDefault("publisher.codec", "json")
+	viper.SetDefault("publisher.compression", "")
+	viper.SetDefault("publisher.compression_threshold", 1024) // 1KB
+	viper.SetDefault("subscriber.mode", "pull")
+	viper.SetDefault("subscriber.deliver_group", "")
+	viper.SetDefault("subscriber.filter_subjects", []string{})
+	viper.SetDefault("subscriber.workers", 10)
+	viper.SetDefault("subscriber.key", "") // unordered when empty
+	viper.SetDefault("subscriber.batch_size", 0)
+	viper.SetDefault("subscriber.batch_wait", time.Second)
+	viper.SetDefault("subscriber.grace_period", 10*time.Second)
+	viper.SetDefault("subscriber.fetch_max_wait", 5*time.Second)
+	viper.SetDefault("subscriber.fetch_max_bytes", 1024*1024) // 1MB
+	viper.SetDefault("subscriber.fetch_heartbeat", time.Second)
+	viper.SetDefault("handler.timeout", 0)
+	viper.SetDefault("ratelimit.rate", 0)
+	viper.SetDefault("ratelimit.burst", 1)
+	viper.SetDefault("ratelimit.per_key_rate", 0)
+	viper.SetDefault("ratelimit.per_key_burst", 1)
+	viper.SetDefault("ratelimit.key", "subject")
+	viper.SetDefault("circuitbreaker.failure_rate", 0) // e.g. 0.5
+	viper.SetDefault("circuitbreaker.window", 20)
+	viper.SetDefault("circuitbreaker.min_calls", 10)
+	viper.SetDefault("circuitbreaker.open_timeout", 30*time.Second)
+	viper.SetDefault("circuitbreaker.probes", 3)
+	viper.SetDefault("schema.bucket", "") // e.g. "SCHEMAS"
+	viper.SetDefault("schema.mode", "reject")
+	viper.SetDefault("schema.compatibility", "backward")
+	viper.SetDefault("schema.file", "")
+	viper.SetDefault("encryption.keys_file", "")
+	viper.SetDefault("encryption.keys", "")
+	viper.SetDefault("encryption.current_key", "")
+	viper.SetDefault("encryption.subjects", []string{})
+	viper.SetDefault("claimcheck.bucket", "") // e.g. "PAYLOADS"
+	viper.SetDefault("claimcheck.threshold", 0)
+	viper.SetDefault("claimcheck.ttl", 24*time.Hour)
+	viper.SetDefault("claimcheck.delete", false)
+	viper.SetDefault("idempotency.bucket", "") // e.g. "PROCESSED"
+	viper.SetDefault("idempotency.ttl", 24*time.Hour)
+	viper.SetDefault("idempotency.key", "header:Nats-Msg-Id")
+	viper.SetDefault("idempotency.claim_timeout", 30*time.Second)
+	viper.SetDefault("outbox.db", "outbox.db")
+	viper.SetDefault("outbox.batch_size", 100)
+	viper.SetDefault("outbox.poll_interval", time.Second)
+	viper.SetDefault("outbox.retention", 24*time.Hour)
+	viper.SetDefault("outbox.id_prefix", "outbox-")
+	viper.SetDefault("health.addr", "") // e.g. ":8080"
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "text")
 
-	// Set environment variables prefix
+	// Set environment variables prefix, mapping nested keys such as
+	// nats.tls.ca_file to APP_NATS_TLS_CA_FILE
 	viper.SetEnvPrefix("APP")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	// Read config file if exists
@@ -43,8 +301,30 @@ func Load() (*Config, error) {
 
 	// Create config struct
 	cfg := &Config{
-		NatsURL:        viper.GetString("nats.url"),
-		NatsMonitorURL: viper.GetString("nats.monitor_url"),
+		Nats: NatsConfig{
+			URL:        viper.GetString("nats.url"),
+			MonitorURL: viper.GetString("nats.monitor_url"),
+			TLS: TLSConfig{
+				CAFile:     viper.GetString("nats.tls.ca_file"),
+				CertFile:   viper.GetString("nats.tls.cert_file"),
+				KeyFile:    viper.GetString("nats.tls.key_file"),
+				ServerName: viper.GetString("nats.tls.server_name"),
+			},
+			Auth: AuthConfig{
+				User:      viper.GetString("nats.user"),
+				Password:  viper.GetString("nats.password"),
+				Token:     viper.GetString("nats.token"),
+				NKeyFile:  viper.GetString("nats.nkey_file"),
+				CredsFile: viper.GetString("nats.creds_file"),
+			},
+			Reconnect: ReconnectConfig{
+				MaxReconnects: viper.GetInt("nats.reconnect.max"),
+				Wait:          viper.GetDuration("nats.reconnect.wait"),
+				Jitter:        viper.GetDuration("nats.reconnect.jitter"),
+				JitterTLS:     viper.GetDuration("nats.reconnect.jitter_tls"),
+				BufSize:       viper.GetInt("nats.reconnect.buf_size"),
+			},
+		},
 		Stream: StreamConfig{
 			Name:        viper.GetString("stream.name"),
 			Subjects:    viper.GetStringSlice("stream.subjects"),
@@ -53,6 +333,82 @@ func Load() (*Config, error) {
 			Storage:     viper.GetString("stream.storage"),
 			MaxAge:      viper.GetInt64("stream.maxAge"),
 		},
+		Publisher: PublisherConfig{
+			Codec:                viper.GetString("publisher.codec"),
+			Compression:          viper.GetString("publisher.compression"),
+			CompressionThreshold: viper.GetInt("publisher.compression_threshold"),
+		},
+		Subscriber: SubscriberConfig{
+			Mode:           viper.GetString("subscriber.mode"),
+			DeliverGroup:   viper.GetString("subscriber.deliver_group"),
+			FilterSubjects: viper.GetStringSlice("subscriber.filter_subjects"),
+			Workers:        viper.GetInt("subscriber.workers"),
+			Key:            viper.GetString("subscriber.key"),
+			BatchSize:      viper.GetInt("subscriber.batch_size"),
+			BatchWait:      viper.GetDuration("subscriber.batch_wait"),
+			GracePeriod:    viper.GetDuration("subscriber.grace_period"),
+			FetchMaxWait:   viper.GetDuration("subscriber.fetch_max_wait"),
+			FetchMaxBytes:  viper.GetInt("subscriber.fetch_max_bytes"),
+			FetchHeartbeat: viper.GetDuration("subscriber.fetch_heartbeat"),
+		},
+		Handler: HandlerConfig{
+			Timeout: viper.GetDuration("handler.timeout"),
+		},
+		RateLimit: RateLimitConfig{
+			Rate:        viper.GetFloat64("ratelimit.rate"),
+			Burst:       viper.GetInt("ratelimit.burst"),
+			PerKeyRate:  viper.GetFloat64("ratelimit.per_key_rate"),
+			PerKeyBurst: viper.GetInt("ratelimit.per_key_burst"),
+			Key:         viper.GetString("ratelimit.key"),
+		},
+		Breaker: CircuitBreakerConfig{
+			FailureRate: viper.GetFloat64("circuitbreaker.failure_rate"),
+			Window:      viper.GetInt("circuitbreaker.window"),
+			MinCalls:    viper.GetInt("circuitbreaker.min_calls"),
+			OpenTimeout: viper.GetDuration("circuitbreaker.open_timeout"),
+			Probes:      viper.GetInt("circuitbreaker.probes"),
+		},
+		Schema: SchemaConfig{
+			Bucket:        viper.GetString("schema.bucket"),
+			Mode:          viper.GetString("schema.mode"),
+			Compatibility: viper.GetString("schema.compatibility"),
+			File:          viper.GetString("schema.file"),
+		},
+		Encryption: EncryptionConfig{
+			KeysFile:   viper.GetString("encryption.keys_file"),
+			Keys:       viper.GetString("encryption.keys"),
+			CurrentKey: viper.GetString("encryption.current_key"),
+			Subjects:   viper.GetStringSlice("encryption.subjects"),
+		},
+		ClaimCheck: ClaimCheckConfig{
+			Bucket:    viper.GetString("claimcheck.bucket"),
+			Threshold: viper.GetInt("claimcheck.threshold"),
+			TTL:       viper.GetDuration("claimcheck.ttl"),
+			Delete:    viper.GetBool("claimcheck.delete"),
+		},
+		Idempotency: IdempotencyConfig{
+			Bucket:       viper.GetString("idempotency.bucket"),
+			TTL:          viper.GetDuration("idempotency.ttl"),
+			Key:          viper.GetString("idempotency.key"),
+			ClaimTimeout: viper.GetDuration("idempotency.claim_timeout"),
+		},
+		Outbox: OutboxConfig{
+			DB:           viper.GetString("outbox.db"),
+			BatchSize:    viper.GetInt("outbox.batch_size"),
+			PollInterval: viper.GetDuration("outbox.poll_interval"),
+			Retention:    viper.GetDuration("outbox.retention"),
+			IDPrefix:     viper.GetString("outbox.id_prefix"),
+		},
+		Health: HealthConfig{
+			Addr: viper.GetString("health.addr"),
+		},
+		Tracing: TracingConfig{
+			Exporter: viper.GetString("tracing.exporter"),
+		},
+		Log: LogConfig{
+			Level:  viper.GetString("log.level"),
+			Format: viper.GetString("log.format"),
+		},
 	}
 
 	return cfg, nil
@@ -0,0 +1,196 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/config"
+)
+
+// Message headers carrying the envelope of an encrypted payload
+const (
+	KeyIDHeader   = "Encryption-Key-Id"   // key-encryption key that wrapped the data key
+	DataKeyHeader = "Encryption-Data-Key" // wrapped data key, base64 encoded
+)
+
+// dataKeySize is the size of the AES-256 key generated for every message
+const dataKeySize = 32
+
+// ErrUnknownKey is returned when opening an envelope wrapped by a key missing
+// from the keyring
+var ErrUnknownKey = errors.New("unknown key-encryption key")
+
+// Envelope is an encrypted payload along with the data key that encrypted it,
+// wrapped by a key-encryption key
+type Envelope struct {
+	KeyID      string
+	DataKey    []byte // nonce followed by the wrapped data key
+	Ciphertext []byte // nonce followed by the encrypted payload
+}
+
+// Keyring holds the key-encryption keys by ID. New payloads are sealed with
+// the current key, while every key can open payloads, so that messages sealed
+// before a rotation stay readable as long as their key is kept.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from AES keys of 16, 24 or 32 bytes
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q not in keyring", current)
+	}
+	k := &Keyring{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// keyFile is the layout of a keyring file
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"` // base64 encoded keys by ID
+}
+
+// LoadFile reads a keyring from a JSON file of the form
+// {"current": "<id>", "keys": {"<id>": "<base64 key>", ...}}
+func LoadFile(path string) (*Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring: %w", err)
+	}
+	var f keyFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("error parsing keyring: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("error decoding key %q: %w", id, err)
+		}
+	}
+	return NewKeyring(f.Current, keys)
+}
+
+// ParseKeys reads a keyring from a list of the form <id>:<base64 key>,...
+// When current is empty the last key listed is current.
+func ParseKeys(spec, current string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	var last string
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding key %q: %w", id, err)
+		}
+		keys[id] = key
+		last = id
+	}
+	if current == "" {
+		current = last
+	}
+	return NewKeyring(current, keys)
+}
+
+// Setup loads the keyring configured by cfg from a file or from the key list,
+// returning nil when neither is set
+func Setup(cfg config.EncryptionConfig) (*Keyring, error) {
+	switch {
+	case cfg.KeysFile != "":
+		return LoadFile(cfg.KeysFile)
+	case cfg.Keys != "":
+		return ParseKeys(cfg.Keys, cfg.CurrentKey)
+	default:
+		return nil, nil
+	}
+}
+
+// Seal encrypts a payload with a new data key and wraps the data key with the
+// current key-encryption key. The additional data, such as the subject, must
+// be passed again to open the envelope.
+func (k *Keyring) Seal(plaintext, additionalData []byte) (Envelope, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return Envelope{}, fmt.Errorf("error generating data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return Envelope{}, err
+	}
+	ciphertext, err := seal(aead, plaintext, additionalData)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	// Bind the wrapped data key to the ID of the key wrapping it
+	wrapped, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{KeyID: k.current, DataKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Open unwraps the data key of an envelope and decrypts its payload
+func (k *Keyring) Open(env Envelope, additionalData []byte) ([]byte, error) {
+	kek, ok := k.keys[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, env.KeyID)
+	}
+	dataKey, err := open(kek, env.DataKey, []byte(env.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(aead, env.Ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting payload: %w", err)
+	}
+	return plaintext, nil
+}
+
+// newAEAD creates an AES-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, which prefixes the result
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts the output of seal
+func open(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,163 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestSealOpen(t *testing.T) {
+	k, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte(`{"id":"o-1"}`)
+
+	env, err := k.Seal(plaintext, []byte("ORDERS.received"))
+	if err != nil {
+		t.Fatalf("Seal error = %v", err)
+	}
+	if env.KeyID != "k1" {
+		t.Errorf("KeyID = %q, want %q", env.KeyID, "k1")
+	}
+	if bytes.Contains(env.Ciphertext, plaintext) {
+		t.Error("ciphertext contains the plaintext")
+	}
+
+	got, err := k.Open(env, []byte("ORDERS.received"))
+	if err != nil {
+		t.Fatalf("Open error = %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Open = %q, want %q", got, plaintext)
+	}
+}
+
+func TestOpenAfterRotation(t *testing.T) {
+	old, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := old.Seal([]byte("payload"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Open(env, nil); err != nil {
+		t.Errorf("Open with the old key kept error = %v", err)
+	}
+	if env, err = rotated.Seal([]byte("payload"), nil); err != nil || env.KeyID != "k2" {
+		t.Errorf("Seal = key %q, %v, want key %q", env.KeyID, err, "k2")
+	}
+}
+
+func TestOpenUnknownKey(t *testing.T) {
+	newer, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := newer.Seal([]byte("payload"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A subscriber that has not received the new key yet
+	older, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := older.Open(env, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	k, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := k.Seal([]byte("payload"), []byte("ORDERS.received"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  Envelope
+		ad   string
+	}{
+		{"other subject", env, "ORDERS.shipped"},
+		{"other key ID", Envelope{KeyID: "k2", DataKey: env.DataKey, Ciphertext: env.Ciphertext}, "ORDERS.received"},
+		{"truncated data key", Envelope{KeyID: "k1", DataKey: env.DataKey[:4], Ciphertext: env.Ciphertext}, "ORDERS.received"},
+		{"flipped ciphertext", Envelope{KeyID: "k1", DataKey: env.DataKey, Ciphertext: flip(env.Ciphertext)}, "ORDERS.received"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := k.Open(tt.env, []byte(tt.ad))
+			if err == nil {
+				t.Fatal("Open succeeded, want an error")
+			}
+			if errors.Is(err, ErrUnknownKey) {
+				t.Errorf("Open error = %v, want a decryption error", err)
+			}
+		})
+	}
+}
+
+// flip returns a copy of data with its last bit flipped
+func flip(data []byte) []byte {
+	out := bytes.Clone(data)
+	out[len(out)-1] ^= 1
+	return out
+}
+
+func TestParseKeys(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+
+	tests := []struct {
+		name    string
+		spec    string
+		current string
+		want    string
+	}{
+		{"last key is current", "k1:" + k1 + ", k2:" + k2, "", "k2"},
+		{"explicit current key", "k1:" + k1 + ",k2:" + k2, "k1", "k1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeys(tt.spec, tt.current)
+			if err != nil {
+				t.Fatalf("ParseKeys error = %v", err)
+			}
+			env, err := k.Seal([]byte("payload"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.KeyID != tt.want {
+				t.Errorf("KeyID = %q, want %q", env.KeyID, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeysInvalid(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	for _, spec := range []string{"", "k1", ":" + k1, "k1:not base64!", "k1:" + base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParseKeys(spec, ""); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded, want an error", spec)
+		}
+	}
+	if _, err := ParseKeys("k1:"+k1, "k2"); err == nil {
+		t.Error("ParseKeys with a missing current key succeeded, want an error")
+	}
+}
@@ -0,0 +1,156 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds how long a single readiness check may take
+const checkTimeout = 2 * time.Second
+
+// Check reports an error if a dependency is not ready
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Server serves liveness, readiness and status endpoints over HTTP
+type Server struct {
+	addr    string
+	started time.Time
+	mux     *http.ServeMux
+	logger  *slog.Logger
+
+	mu     sync.RWMutex
+	checks []namedCheck
+	status func() any
+}
+
+// NewServer creates a new health server listening on the given address
+func NewServer(addr string, logger *slog.Logger) *Server {
+	s := &Server{
+		addr:    addr,
+		started: time.Now(),
+		mux:     http.NewServeMux(),
+		logger:  logger,
+	}
+
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/status", s.handleStatus)
+
+	return s
+}
+
+// AddCheck registers a named readiness check
+func (s *Server) AddCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+}
+
+// Handle registers an additional handler, such as /metrics, on the server
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// SetStatus sets the function providing the details reported on /status
+func (s *Server) SetStatus(fn func() any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = fn
+}
+
+// Run serves HTTP requests until the context is cancelled
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("Health server listening", "addr", s.addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("error serving health endpoints: %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("error shutting down health server: %w", err)
+		}
+		return nil
+	}
+}
+
+// handleHealthz reports that the process is alive
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz runs every readiness check and reports their results
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	checks := append([]namedCheck(nil), s.checks...)
+	s.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	code := http.StatusOK
+	results := make(map[string]string, len(checks))
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			code = http.StatusServiceUnavailable
+			results[c.name] = err.Error()
+			continue
+		}
+		results[c.name] = "ok"
+	}
+
+	status := "ok"
+	if code != http.StatusOK {
+		status = "unavailable"
+	}
+	s.writeJSON(w, code, map[string]any{
+		"status": status,
+		"checks": results,
+	})
+}
+
+// handleStatus reports the process uptime along with the registered status details
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	status := s.status
+	s.mu.RUnlock()
+
+	resp := map[string]any{
+		"started": s.started.UTC().Format(time.RFC3339),
+		"uptime":  time.Since(s.started).Round(time.Second).String(),
+	}
+	if status != nil {
+		resp["details"] = status()
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Warn("Error writing health response", "error", err)
+	}
+}
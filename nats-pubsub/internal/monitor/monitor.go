@@ -5,7 +5,7 @@ import (
     "encoding/json"
     "fmt"
     "io"
-    "log"
+    "log/slog"
     "net/http"
     "net/url"
     "os"
@@ -16,6 +16,7 @@ import (
 type Monitor struct {
     baseURL string
     client  *http.Client
+    logger  *slog.Logger
 }
 
 // ServerInfo holds basic NATS server information
@@ -70,7 +71,7 @@ type StreamState struct {
 }
 
 // NewMonitor creates a new monitor instance
-func NewMonitor(baseURL string) *Monitor {
+func NewMonitor(baseURL string, logger *slog.Logger) *Monitor {
     // Use environment variable if set
     if envURL := os.Getenv("APP_NATS_MONITOR_URL"); envURL != "" {
         baseURL = envURL
@@ -82,17 +83,18 @@ func NewMonitor(baseURL string) *Monitor {
 
     // Ensure the URL is properly formatted
     if _, err := url.Parse(baseURL); err != nil {
-        log.Printf("Warning: Invalid monitor URL %q, falling back to default", baseURL)
+        logger.Warn("Invalid monitor URL, falling back to default", "url", baseURL)
         baseURL = "http://localhost:8222"
     }
 
-    log.Printf("Using NATS monitor URL: %s", baseURL)
+    logger.Info("Using NATS monitor URL", "url", baseURL)
     
     return &Monitor{
         baseURL: baseURL,
         client: &http.Client{
             Timeout: 5 * time.Second,
         },
+        logger: logger,
     }
 }
 
@@ -105,14 +107,14 @@ func (m *Monitor) Run(ctx context.Context, interval time.Duration) error {
     time.Sleep(2 * time.Second)
 
     if err := m.logInfo(); err != nil {
-        log.Printf("Error fetching initial information: %v", err)
+        m.logger.Error("Error fetching initial information", "error", err)
     }
 
     for {
         select {
         case <-ticker.C:
             if err := m.logInfo(); err != nil {
-                log.Printf("Error fetching information: %v", err)
+                m.logger.Error("Error fetching information", "error", err)
             }
         case <-ctx.Done():
             return nil
@@ -127,13 +129,14 @@ func (m *Monitor) logInfo() error {
         return fmt.Errorf("error fetching server info: %w", err)
     }
     
-    log.Printf("=== NATS Server Info ===")
-    log.Printf("Server ID: %s", serverInfo.ServerID)
-    log.Printf("Version: %s", serverInfo.Version)
-    log.Printf("Uptime: %s", serverInfo.Uptime)
-    log.Printf("Connections: %d", serverInfo.Connections)
-    log.Printf("Memory: %d MB", serverInfo.Mem/(1024*1024))
-    log.Printf("CPU: %.2f%%", serverInfo.CPU)
+    m.logger.Info("NATS server info",
+        "server_id", serverInfo.ServerID,
+        "version", serverInfo.Version,
+        "uptime", serverInfo.Uptime,
+        "connections", serverInfo.Connections,
+        "memory_mb", serverInfo.Mem/(1024*1024),
+        "cpu_percent", serverInfo.CPU,
+    )
     
     // Fetch JetStream info
     jsInfo, err := m.getJetStreamInfo()
@@ -141,28 +144,33 @@ func (m *Monitor) logInfo() error {
         return fmt.Errorf("error fetching JetStream info: %w", err)
     }
 
-    log.Printf("\n=== JetStream Info ===")
-    log.Printf("Total Streams: %d", jsInfo.Streams)
-    log.Printf("Total Consumers: %d", jsInfo.Consumers)
-    log.Printf("Total Messages: %d", jsInfo.Messages)
-    log.Printf("Total Storage: %.2f MB", float64(jsInfo.Storage)/(1024*1024))
+    m.logger.Info("JetStream info",
+        "streams", jsInfo.Streams,
+        "consumers", jsInfo.Consumers,
+        "messages", jsInfo.Messages,
+        "storage_mb", float64(jsInfo.Storage)/(1024*1024),
+    )
 
     // Log details for each stream
-    if len(jsInfo.AccountDetails) > 0 {
-        log.Printf("\n=== Stream Details ===")
-        for _, account := range jsInfo.AccountDetails {
-            for _, stream := range account.StreamDetail {
-                log.Printf("Stream: %s", stream.Name)
-                log.Printf("  Created: %s", stream.Created)
-                log.Printf("  Messages: %d", stream.State.Messages)
-                log.Printf("  Storage: %.2f MB", float64(stream.State.Bytes)/(1024*1024))
-                log.Printf("  Consumers: %d", stream.State.ConsumerCount)
-                if stream.State.Messages > 0 {
-                    log.Printf("  Sequence: %d -> %d", stream.State.FirstSeq, stream.State.LastSeq)
-                    log.Printf("  First Message: %s", stream.State.FirstTS.Format(time.RFC3339))
-                    log.Printf("  Last Message: %s", stream.State.LastTS.Format(time.RFC3339))
-                }
+    for _, account := range jsInfo.AccountDetails {
+        for _, stream := range account.StreamDetail {
+            attrs := []any{
+                "stream", stream.Name,
+                "account", account.Name,
+                "created", stream.Created,
+                "messages", stream.State.Messages,
+                "storage_mb", float64(stream.State.Bytes)/(1024*1024),
+                "consumers", stream.State.ConsumerCount,
             }
+            if stream.State.Messages > 0 {
+                attrs = append(attrs,
+                    "first_seq", stream.State.FirstSeq,
+                    "last_seq", stream.State.LastSeq,
+                    "first_ts", stream.State.FirstTS.Format(time.RFC3339),
+                    "last_ts", stream.State.LastTS.Format(time.RFC3339),
+                )
+            }
+            m.logger.Info("Stream details", attrs...)
         }
     }
     
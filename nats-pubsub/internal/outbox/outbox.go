@@ -0,0 +1,159 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	// Register the pure Go SQLite driver as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// schema creates the outbox table. Unsent events are read in insertion order,
+// and sent events are purged by age. Events that cannot be published are kept
+// with the time and error of the failure.
+const schema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	subject    TEXT    NOT NULL,
+	payload    BLOB    NOT NULL,
+	headers    TEXT,
+	created_at INTEGER NOT NULL,
+	sent_at    INTEGER,
+	failed_at  INTEGER,
+	error      TEXT
+);
+CREATE INDEX IF NOT EXISTS outbox_unsent ON outbox (id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_sent_at ON outbox (sent_at) WHERE sent_at IS NOT NULL;
+`
+
+// failedColumns adds the failure columns to tables created before they existed
+const failedColumns = `
+ALTER TABLE outbox ADD COLUMN failed_at INTEGER;
+ALTER TABLE outbox ADD COLUMN error TEXT;
+`
+
+// Event is a message waiting in the outbox to be published
+type Event struct {
+	ID      int64
+	Subject string
+	Payload []byte
+	Header  nats.Header
+}
+
+// Open opens a SQLite database and creates the outbox table if needed. The
+// database is opened in WAL mode with a busy timeout, so that the relay can
+// poll while the application writes.
+func Open(ctx context.Context, path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migrate creates the outbox table if it does not exist, and adds the columns
+// of later versions to an existing one
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("error creating outbox table: %w", err)
+	}
+
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info('outbox') WHERE name = 'failed_at'`).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("error reading outbox table: %w", err)
+	}
+	if n == 0 {
+		if _, err := db.ExecContext(ctx, failedColumns); err != nil {
+			return fmt.Errorf("error migrating outbox table: %w", err)
+		}
+	}
+	return nil
+}
+
+// Insert adds an event to the outbox within the caller's transaction, so that
+// it is only published if the transaction commits. It returns the event ID.
+func Insert(ctx context.Context, tx *sql.Tx, subject string, payload []byte, header nats.Header) (int64, error) {
+	var headers []byte
+	if len(header) > 0 {
+		var err error
+		if headers, err = json.Marshal(header); err != nil {
+			return 0, fmt.Errorf("error marshaling headers: %w", err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO outbox (subject, payload, headers, created_at) VALUES (?, ?, ?, ?)`,
+		subject, payload, headers, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting outbox event: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// pending returns up to limit unsent events in insertion order, skipping those
+// that failed for good
+func pending(ctx context.Context, db *sql.DB, limit int) ([]Event, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, subject, payload, headers FROM outbox WHERE sent_at IS NULL AND failed_at IS NULL ORDER BY id LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var (
+			ev      Event
+			headers []byte
+		)
+		if err := rows.Scan(&ev.ID, &ev.Subject, &ev.Payload, &headers); err != nil {
+			return nil, fmt.Errorf("error reading outbox: %w", err)
+		}
+		if len(headers) > 0 {
+			if err := json.Unmarshal(headers, &ev.Header); err != nil {
+				return nil, fmt.Errorf("error unmarshaling headers of event %d: %w", ev.ID, err)
+			}
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// markSent records that an event has been published
+func markSent(ctx context.Context, db *sql.DB, id int64) error {
+	if _, err := db.ExecContext(ctx, `UPDATE outbox SET sent_at = ? WHERE id = ?`, time.Now().UnixMilli(), id); err != nil {
+		return fmt.Errorf("error marking event %d as sent: %w", id, err)
+	}
+	return nil
+}
+
+// markFailed records that an event cannot be published, so that it is no
+// longer read. Clearing failed_at publishes it again.
+func markFailed(ctx context.Context, db *sql.DB, id int64, cause error) error {
+	if _, err := db.ExecContext(ctx, `UPDATE outbox SET failed_at = ?, error = ? WHERE id = ?`, time.Now().UnixMilli(), cause.Error(), id); err != nil {
+		return fmt.Errorf("error marking event %d as failed: %w", id, err)
+	}
+	return nil
+}
+
+// purge deletes events sent before the cutoff
+func purge(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < ?`, before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("error purging outbox: %w", err)
+	}
+	return res.RowsAffected()
+}
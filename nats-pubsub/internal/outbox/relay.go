@@ -0,0 +1,237 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/pubsub"
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/nats-io/nats.go"
+)
+
+// Relay defaults
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+	DefaultRetention    = 24 * time.Hour
+	DefaultIDPrefix     = "outbox-"
+)
+
+// purgeInterval is how often sent events older than the retention are deleted
+const purgeInterval = time.Minute
+
+// Relay publishes the events of an outbox table in insertion order and marks
+// them as sent. Events are published with their ID as message ID, so that an
+// event published again after a crash, before it was marked as sent, is
+// dropped by the stream's duplicate window. Run a single relay per database.
+type Relay struct {
+	db           *sql.DB
+	publisher    *pubsub.Publisher
+	logger       *slog.Logger
+	batchSize    int
+	pollInterval time.Duration
+	retention    time.Duration
+	idPrefix     string
+
+	relayed   atomic.Uint64
+	failed    atomic.Uint64
+	mu        sync.Mutex
+	lastErr   error
+	lastErrAt time.Time
+	lastPurge time.Time
+}
+
+// RelayOption configures optional Relay behaviour
+type RelayOption func(*Relay)
+
+// WithLogger sets the logger, slog.Default() is used otherwise
+func WithLogger(logger *slog.Logger) RelayOption {
+	return func(r *Relay) {
+		r.logger = logger
+	}
+}
+
+// WithBatchSize sets how many events are read from the table at once
+func WithBatchSize(n int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithPollInterval sets how often the table is polled once it is empty
+func WithPollInterval(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.pollInterval = d
+	}
+}
+
+// WithRetention sets how long sent events are kept, 0 keeps them forever
+func WithRetention(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.retention = d
+	}
+}
+
+// WithIDPrefix sets the prefix of message IDs, which must differ between
+// outboxes publishing to the same stream
+func WithIDPrefix(prefix string) RelayOption {
+	return func(r *Relay) {
+		r.idPrefix = prefix
+	}
+}
+
+// NewRelay creates a relay publishing the outbox events of db
+func NewRelay(db *sql.DB, publisher *pubsub.Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		db:           db,
+		publisher:    publisher,
+		batchSize:    DefaultBatchSize,
+		pollInterval: DefaultPollInterval,
+		retention:    DefaultRetention,
+		idPrefix:     DefaultIDPrefix,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.logger == nil {
+		r.logger = slog.Default()
+	}
+	return r
+}
+
+// RelayStats is a snapshot of the relay's counters
+type RelayStats struct {
+	Relayed     uint64     `json:"relayed"`
+	Failed      uint64     `json:"failed"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Stats returns a snapshot of the relay's counters
+func (r *Relay) Stats() RelayStats {
+	stats := RelayStats{
+		Relayed: r.relayed.Load(),
+		Failed:  r.failed.Load(),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastErr != nil {
+		at := r.lastErrAt
+		stats.LastError = r.lastErr.Error()
+		stats.LastErrorAt = &at
+	}
+	return stats
+}
+
+// Run relays events until ctx is cancelled. Full batches are followed by the
+// next one straight away, otherwise the table is polled again after the poll
+// interval. An event that fails to publish stops its batch, so events stay in
+// order, and is retried on the next poll. Events that cannot be published at
+// all, see pubsub.Permanent, are marked as failed and skipped instead.
+func (r *Relay) Run(ctx context.Context) error {
+	if r.batchSize < 1 {
+		return fmt.Errorf("invalid batch size %d", r.batchSize)
+	}
+	if r.pollInterval <= 0 {
+		return fmt.Errorf("invalid poll interval %s", r.pollInterval)
+	}
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("Error relaying outbox events", "error", err)
+			r.setErr(err)
+		}
+		if err == nil && n == r.batchSize {
+			continue
+		}
+		r.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes the next batch of events, returning how many were sent or
+// marked as failed
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	events, err := pending(ctx, r.db, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, ev := range events {
+		msg := nats.NewMsg(ev.Subject)
+		msg.Data = ev.Payload
+		for k, v := range ev.Header {
+			msg.Header[k] = v
+		}
+		msg.Header.Set(nats.MsgIdHdr, r.idPrefix+strconv.FormatInt(ev.ID, 10))
+
+		// Publish within the trace the event was inserted in, the producer span
+		// replacing the stored trace context with its own
+		pubCtx := ctx
+		if ev.Header != nil {
+			pubCtx = tracing.Extract(ctx, ev.Header)
+		}
+		err := r.publisher.PublishMsg(pubCtx, msg)
+		if err != nil && pubsub.Permanent(err) {
+			// Retrying would block the events behind it forever
+			r.failed.Add(1)
+			r.logger.Error("Outbox event cannot be published", "event_id", ev.ID, "subject", ev.Subject, "error", err)
+			r.setErr(fmt.Errorf("error publishing event %d: %w", ev.ID, err))
+			if err := markFailed(ctx, r.db, ev.ID, err); err != nil {
+				return i, err
+			}
+			continue
+		}
+		if err != nil {
+			r.failed.Add(1)
+			return i, fmt.Errorf("error publishing event %d: %w", ev.ID, err)
+		}
+		if err := markSent(ctx, r.db, ev.ID); err != nil {
+			return i, err
+		}
+		r.relayed.Add(1)
+		r.logger.Debug("Relayed outbox event", "event_id", ev.ID, "subject", ev.Subject)
+	}
+	return len(events), nil
+}
+
+// purge deletes events sent before the retention, at most once per purge interval
+func (r *Relay) purge(ctx context.Context) {
+	if r.retention <= 0 || time.Since(r.lastPurge) < purgeInterval {
+		return
+	}
+	r.lastPurge = time.Now()
+
+	n, err := purge(ctx, r.db, time.Now().Add(-r.retention))
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Warn("Error purging outbox", "error", err)
+		}
+		return
+	}
+	if n > 0 {
+		r.logger.Info("Purged sent outbox events", "count", n)
+	}
+}
+
+func (r *Relay) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastErr = err
+	r.lastErrAt = time.Now()
+}
@@ -0,0 +1,318 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/pubsub"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// fakeJetStream records published messages, failing those on the subjects in
+// fail with the given error
+type fakeJetStream struct {
+	jetstream.JetStream
+
+	mu   sync.Mutex
+	msgs []*nats.Msg
+	fail map[string]error
+}
+
+func (js *fakeJetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if err := js.fail[msg.Subject]; err != nil {
+		return nil, err
+	}
+	js.msgs = append(js.msgs, msg)
+	return &jetstream.PubAck{Sequence: uint64(len(js.msgs))}, nil
+}
+
+func (js *fakeJetStream) published() []*nats.Msg {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return append([]*nats.Msg(nil), js.msgs...)
+}
+
+// openTestDB opens an in-memory outbox holding events on the given subjects
+func openTestDB(t *testing.T, subjects ...string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would open a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	if err := Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, subject := range subjects {
+		header := nats.Header{"Trace": []string{fmt.Sprint(i)}}
+		if _, err := Insert(ctx, tx, subject, []byte(fmt.Sprintf(`{"n":%d}`, i)), header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestRelay(db *sql.DB, js *fakeJetStream, opts ...RelayOption) *Relay {
+	publisher := pubsub.NewPublisher(js, "ORDERS.received")
+	return NewRelay(db, publisher, append([]RelayOption{WithIDPrefix("test-")}, opts...)...)
+}
+
+func countPending(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	events, err := pending(context.Background(), db, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(events)
+}
+
+func TestRelayBatches(t *testing.T) {
+	db := openTestDB(t, "ORDERS.a", "ORDERS.b", "ORDERS.c", "ORDERS.d", "ORDERS.e")
+	js := &fakeJetStream{}
+	r := newTestRelay(db, js, WithBatchSize(2))
+
+	for _, want := range []int{2, 2, 1, 0} {
+		n, err := r.relay(context.Background())
+		if err != nil {
+			t.Fatalf("relay error = %v", err)
+		}
+		if n != want {
+			t.Fatalf("relay = %d events, want %d", n, want)
+		}
+	}
+
+	msgs := js.published()
+	if len(msgs) != 5 {
+		t.Fatalf("published %d messages, want 5", len(msgs))
+	}
+	for i, msg := range msgs {
+		if want := fmt.Sprintf("ORDERS.%c", 'a'+i); msg.Subject != want {
+			t.Errorf("message %d subject = %s, want %s", i, msg.Subject, want)
+		}
+		if want := fmt.Sprintf("test-%d", i+1); msg.Header.Get(nats.MsgIdHdr) != want {
+			t.Errorf("message %d ID = %s, want %s", i, msg.Header.Get(nats.MsgIdHdr), want)
+		}
+		if want := fmt.Sprint(i); msg.Header.Get("Trace") != want {
+			t.Errorf("message %d header = %s, want %s", i, msg.Header.Get("Trace"), want)
+		}
+	}
+	if got := countPending(t, db); got != 0 {
+		t.Errorf("%d events left unsent, want 0", got)
+	}
+	if stats := r.Stats(); stats.Relayed != 5 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want 5 relayed and none failed", stats)
+	}
+}
+
+func TestRelayStopsBatchOnFailure(t *testing.T) {
+	db := openTestDB(t, "ORDERS.a", "ORDERS.b", "ORDERS.c")
+	js := &fakeJetStream{fail: map[string]error{"ORDERS.b": errors.New("no responders")}}
+	r := newTestRelay(db, js)
+
+	n, err := r.relay(context.Background())
+	if err == nil {
+		t.Fatal("relay succeeded, want an error")
+	}
+	if n != 1 {
+		t.Errorf("relay = %d events, want 1", n)
+	}
+	if got := countPending(t, db); got != 2 {
+		t.Errorf("%d events left unsent, want 2", got)
+	}
+
+	// The failed event is retried first on the next poll
+	js.mu.Lock()
+	js.fail = nil
+	js.mu.Unlock()
+	if n, err = r.relay(context.Background()); err != nil || n != 2 {
+		t.Fatalf("relay = %d, %v, want 2 events", n, err)
+	}
+	msgs := js.published()
+	if len(msgs) != 3 || msgs[1].Subject != "ORDERS.b" || msgs[2].Subject != "ORDERS.c" {
+		t.Errorf("published %d messages out of order", len(msgs))
+	}
+}
+
+func TestRelaySkipsPermanentFailure(t *testing.T) {
+	db := openTestDB(t, "ORDERS.a", "ORDERS.b", "ORDERS.c")
+	js := &fakeJetStream{fail: map[string]error{"ORDERS.b": nats.ErrMaxPayload}}
+	r := newTestRelay(db, js)
+
+	n, err := r.relay(context.Background())
+	if err != nil {
+		t.Fatalf("relay error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("relay = %d events, want 3", n)
+	}
+	msgs := js.published()
+	if len(msgs) != 2 || msgs[0].Subject != "ORDERS.a" || msgs[1].Subject != "ORDERS.c" {
+		t.Errorf("published %d messages, want those around the failed event", len(msgs))
+	}
+	if got := countPending(t, db); got != 0 {
+		t.Errorf("%d events left unsent, want 0", got)
+	}
+
+	var cause string
+	if err := db.QueryRow(`SELECT error FROM outbox WHERE id = 2 AND failed_at IS NOT NULL`).Scan(&cause); err != nil {
+		t.Fatalf("failed event not recorded: %v", err)
+	}
+	if cause != nats.ErrMaxPayload.Error() {
+		t.Errorf("recorded error = %q, want %q", cause, nats.ErrMaxPayload)
+	}
+	if stats := r.Stats(); stats.Relayed != 2 || stats.Failed != 1 || stats.LastError == "" {
+		t.Errorf("stats = %+v, want 2 relayed and 1 failed", stats)
+	}
+}
+
+func TestMigrateAddsFailureColumns(t *testing.T) {
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	old := `CREATE TABLE outbox (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		subject    TEXT    NOT NULL,
+		payload    BLOB    NOT NULL,
+		headers    TEXT,
+		created_at INTEGER NOT NULL,
+		sent_at    INTEGER
+	)`
+	if _, err := db.ExecContext(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	// Migrating twice must leave the table as it is
+	for range 2 {
+		if err := Migrate(ctx, db); err != nil {
+			t.Fatalf("Migrate error = %v", err)
+		}
+	}
+	if err := markFailed(ctx, db, 1, errors.New("invalid")); err != nil {
+		t.Errorf("markFailed error = %v", err)
+	}
+}
+
+func TestRelayKeepsTrace(t *testing.T) {
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	db := openTestDB(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := nats.Header{"traceparent": []string{"00-" + traceID + "-00f067aa0ba902b7-01"}}
+	if _, err := Insert(context.Background(), tx, "ORDERS.a", []byte(`{}`), header); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	js := &fakeJetStream{}
+	if _, err := newTestRelay(db, js).relay(context.Background()); err != nil {
+		t.Fatalf("relay error = %v", err)
+	}
+	msgs := js.published()
+	if len(msgs) != 1 {
+		t.Fatalf("published %d messages, want 1", len(msgs))
+	}
+	parent := msgs[0].Header.Get("traceparent")
+	if !strings.HasPrefix(parent, "00-"+traceID+"-") || strings.Contains(parent, "00f067aa0ba902b7") {
+		t.Errorf("traceparent = %s, want a producer span in trace %s", parent, traceID)
+	}
+}
+
+func TestRelayRunDrainsFullBatches(t *testing.T) {
+	db := openTestDB(t, "ORDERS.a", "ORDERS.b", "ORDERS.c", "ORDERS.d", "ORDERS.e")
+	js := &fakeJetStream{}
+	// Full batches must not wait for the poll interval
+	r := newTestRelay(db, js, WithBatchSize(2), WithPollInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(js.published()) < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("published %d messages, want 5", len(js.published()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run error = %v", err)
+	}
+}
+
+func TestRelayRunInvalidOptions(t *testing.T) {
+	db := openTestDB(t)
+	for _, opt := range []RelayOption{WithBatchSize(0), WithBatchSize(-1), WithPollInterval(0)} {
+		r := newTestRelay(db, &fakeJetStream{}, opt)
+		if err := r.Run(context.Background()); err == nil {
+			t.Errorf("Run with batch size %d and poll interval %s succeeded, want an error", r.batchSize, r.pollInterval)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db := openTestDB(t, "ORDERS.a", "ORDERS.b", "ORDERS.c")
+	ctx := context.Background()
+
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	if _, err := db.ExecContext(ctx, `UPDATE outbox SET sent_at = ? WHERE id = 1`, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := markSent(ctx, db, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := purge(ctx, db, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("purge error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d events, want 1", n)
+	}
+	var left int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 2 {
+		t.Errorf("%d events left, want 2", left)
+	}
+}
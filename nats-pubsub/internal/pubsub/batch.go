@@ -0,0 +1,218 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BatchHandler processes a batch of messages. Returning nil acknowledges every
+// message, returning a *BatchError naks only the messages it lists and any
+// other error naks the whole batch.
+type BatchHandler func(ctx context.Context, msgs []jetstream.Msg) error
+
+// BatchError reports the messages of a batch that failed, keyed by their index
+// in the batch
+type BatchError struct {
+	Failed map[int]error
+}
+
+// Fail records the failure of the message at index i
+func (e *BatchError) Fail(i int, err error) {
+	if e.Failed == nil {
+		e.Failed = make(map[int]error)
+	}
+	e.Failed[i] = err
+}
+
+func (e *BatchError) Error() string {
+	first := -1
+	for i := range e.Failed {
+		if first < 0 || i < first {
+			first = i
+		}
+	}
+	switch {
+	case first < 0:
+		return "batch failed"
+	case len(e.Failed) == 1:
+		return fmt.Sprintf("message %d of batch failed: %v", first, e.Failed[first])
+	default:
+		return fmt.Sprintf("%d messages of batch failed, first message %d: %v", len(e.Failed), first, e.Failed[first])
+	}
+}
+
+// batchWorker collects messages from the work channel into batches until it is
+// closed, freeing a slot for each message once its batch is settled. Once ctx
+// is cancelled, batches are nak'ed instead of processed.
+func (s *Subscriber) batchWorker(ctx, handlerCtx context.Context, id int, workChan <-chan jetstream.Msg, slots chan<- struct{}) {
+	logger := s.logger.With("worker_id", id)
+	logger.Info("Worker started")
+	defer logger.Info("Worker stopped")
+
+	for {
+		batch, open := s.collect(ctx, workChan)
+		if len(batch) > 0 {
+			if ctx.Err() != nil {
+				for _, msg := range batch {
+					s.settle(logger.With(messageAttrs(msg)...), msg, outcomeNak)
+				}
+			} else {
+				s.metrics.addBusyWorkers(s.streamName, 1)
+				s.processBatch(handlerCtx, logger, batch)
+				s.metrics.addBusyWorkers(s.streamName, -1)
+			}
+			for range batch {
+				slots <- struct{}{}
+			}
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+// collect waits for a message, then gathers more until the batch is full, the
+// max wait has passed since the first message or ctx is cancelled. It reports
+// whether the work channel is still open.
+func (s *Subscriber) collect(ctx context.Context, workChan <-chan jetstream.Msg) ([]jetstream.Msg, bool) {
+	msg, ok := <-workChan
+	if !ok {
+		return nil, false
+	}
+	s.metrics.addInFlight(s.streamName, -1)
+	batch := append(make([]jetstream.Msg, 0, s.batchSize), msg)
+
+	timer := time.NewTimer(s.batchWait)
+	defer timer.Stop()
+
+	for len(batch) < s.batchSize {
+		select {
+		case msg, ok := <-workChan:
+			if !ok {
+				return batch, false
+			}
+			s.metrics.addInFlight(s.streamName, -1)
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch, true
+		case <-ctx.Done():
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// processBatch handles a batch of messages and settles each according to the result
+func (s *Subscriber) processBatch(ctx context.Context, logger *slog.Logger, batch []jetstream.Msg) {
+	s.received.Add(uint64(len(batch)))
+
+	// Hand back batches collected before the circuit breaker opened
+	if s.breaker.State() == BreakerOpen {
+		for _, msg := range batch {
+			s.settleAfter(logger.With(messageAttrs(msg)...), msg, outcomeNak, s.breaker.cfg.OpenTimeout)
+		}
+		return
+	}
+
+	// Settle messages that cannot be prepared and hand the rest to the handler
+	valid := batch[:0]
+	for _, msg := range batch {
+		msgLogger := logger.With(messageAttrs(msg)...)
+		prepared, err := s.prepare(ctx, msgLogger, msg)
+		if err != nil {
+			msgLogger.Error("Error preparing message", "error", err)
+			s.lastErr.set(err)
+			s.settleAfter(msgLogger, msg, rejectOutcome(err), retryDelay(err))
+			s.breaker.skip()
+			continue
+		}
+		valid = append(valid, prepared)
+	}
+	if len(valid) == 0 {
+		return
+	}
+	batch = valid
+
+	start := time.Now()
+	logger = logger.With("batch_size", len(batch))
+
+	// Link the batch to the traces started by the publishers
+	headers := make([]nats.Header, len(batch))
+	for i, msg := range batch {
+		headers[i] = msg.Headers()
+	}
+	ctx, span := tracing.StartBatchConsumerSpan(ctx, strings.Join(s.subjects, ","), headers,
+		attribute.String("messaging.nats.stream", s.streamName),
+	)
+	defer span.End()
+
+	err := s.handleBatch(withLogger(ctx, logger), batch)
+	if err == nil {
+		s.metrics.observeHandler(s.streamName, outcomeAck, time.Since(start))
+		for _, msg := range batch {
+			s.metrics.observeHandlerResult(msg.Subject(), resultOK)
+			s.breaker.record(false)
+			s.settle(logger.With(messageAttrs(msg)...), msg, outcomeAck)
+		}
+		return
+	}
+
+	logger.Error("Error handling batch", "error", err)
+	tracing.RecordError(span, err)
+	s.lastErr.set(err)
+	s.metrics.observeHandler(s.streamName, outcomeNak, time.Since(start))
+
+	// Nak only the listed messages if the handler reported them, otherwise all
+	var batchErr *BatchError
+	partial := errors.As(err, &batchErr)
+	for i, msg := range batch {
+		msgLogger := logger.With(messageAttrs(msg)...)
+		if !partial {
+			s.metrics.observeHandlerResult(msg.Subject(), handlerResult(err))
+			s.recordResult(err)
+			s.settleAfter(msgLogger, msg, outcomeNak, retryDelay(err))
+			continue
+		}
+		if msgErr, failed := batchErr.Failed[i]; failed {
+			msgLogger.Error("Error handling message", "error", msgErr)
+			s.metrics.observeHandlerResult(msg.Subject(), handlerResult(msgErr))
+			s.recordResult(msgErr)
+			s.settleAfter(msgLogger, msg, outcomeNak, retryDelay(msgErr))
+			continue
+		}
+		s.metrics.observeHandlerResult(msg.Subject(), resultOK)
+		s.breaker.record(false)
+		s.settle(msgLogger, msg, outcomeAck)
+	}
+}
+
+// handleBatch runs the batch handler in a span of its own and turns a panic
+// into a *PanicError, so that the batch is nak'ed instead of the process
+// crashing. Middleware wraps single messages, so this does the work of the
+// Trace and Recover middleware for batches, while handler results are
+// recorded per message as the Instrument middleware would.
+func (s *Subscriber) handleBatch(ctx context.Context, batch []jetstream.Msg) (err error) {
+	ctx, span := tracing.StartSpan(ctx, strings.Join(s.subjects, ",")+" handle")
+	defer span.End()
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			LoggerFromContext(ctx).Error("Batch handler panicked", "panic", v, "stack", string(stack))
+			err = &PanicError{Value: v, Stack: stack}
+		}
+		if err != nil {
+			tracing.RecordError(span, err)
+		}
+	}()
+	return s.batchHandler(ctx, batch)
+}
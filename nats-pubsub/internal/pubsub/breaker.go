@@ -0,0 +1,319 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// BreakerState is the state of the subscriber's circuit breaker
+type BreakerState string
+
+// Circuit breaker states. A closed breaker lets every message through, an open
+// one pauses fetching, and a half-open one lets a few probe messages through
+// to decide whether to close or open again.
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// Circuit breaker defaults
+const (
+	DefaultBreakerFailureRate = 0.5              // share of failed handler calls opening the breaker
+	DefaultBreakerWindow      = 20               // recent handler calls the failure rate is computed over
+	DefaultBreakerMinCalls    = 10               // handler calls needed before the breaker can open
+	DefaultBreakerOpenTimeout = 30 * time.Second // how long the breaker stays open before probing
+	DefaultBreakerProbes      = 3                // messages let through while half-open
+)
+
+// errBreakerOpen stops fetching once the circuit breaker opens
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// BreakerConfig configures the circuit breaker. Zero fields take the defaults.
+type BreakerConfig struct {
+	FailureRate float64       // share of failed handler calls within the window opening the breaker
+	Window      int           // number of recent handler calls the failure rate is computed over
+	MinCalls    int           // handler calls needed within the window before the breaker can open
+	OpenTimeout time.Duration // how long the breaker stays open before probing
+	Probes      int           // messages let through while half-open, all must succeed to close
+}
+
+// withDefaults fills in the zero fields
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureRate <= 0 {
+		c.FailureRate = DefaultBreakerFailureRate
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultBreakerWindow
+	}
+	if c.MinCalls <= 0 {
+		c.MinCalls = DefaultBreakerMinCalls
+	}
+	c.MinCalls = min(c.MinCalls, c.Window)
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if c.Probes <= 0 {
+		c.Probes = DefaultBreakerProbes
+	}
+	return c
+}
+
+// breaker is a circuit breaker tracking the failure rate of the last handler
+// calls. A nil *breaker is always closed.
+type breaker struct {
+	cfg      BreakerConfig
+	onChange func(BreakerState)
+
+	mu        sync.Mutex
+	state     BreakerState
+	results   []bool // ring of the last results, true for failures
+	next      int
+	count     int
+	failures  int
+	probes    int           // probes admitted while half-open
+	successes int           // probes succeeded while half-open
+	changed   chan struct{} // closed on every state change
+}
+
+func newBreaker(cfg BreakerConfig, onChange func(BreakerState)) *breaker {
+	cfg = cfg.withDefaults()
+	return &breaker{
+		cfg:      cfg,
+		onChange: onChange,
+		state:    BreakerClosed,
+		results:  make([]bool, cfg.Window),
+		changed:  make(chan struct{}),
+	}
+}
+
+// State returns the current state
+func (b *breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// admit reports whether a message may be handled, counting it as a probe
+// while half-open. It also returns the state and a channel closed on the next
+// state change, to wait on when the message is not admitted.
+func (b *breaker) admit() (bool, BreakerState, <-chan struct{}) {
+	if b == nil {
+		return true, BreakerClosed, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		return true, b.state, b.changed
+	case BreakerHalfOpen:
+		if b.probes < b.cfg.Probes {
+			b.probes++
+			return true, b.state, b.changed
+		}
+	}
+	return false, b.state, b.changed
+}
+
+// record records the result of a handler call. While half-open, a failure
+// opens the breaker again, and the breaker closes once every probe succeeded.
+func (b *breaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.Probes {
+			b.reset()
+			b.transition(BreakerClosed)
+		}
+	case BreakerClosed:
+		if b.count == len(b.results) {
+			if b.results[b.next] {
+				b.failures--
+			}
+		} else {
+			b.count++
+		}
+		b.results[b.next] = failed
+		b.next = (b.next + 1) % len(b.results)
+		if failed {
+			b.failures++
+		}
+		if b.count >= b.cfg.MinCalls && float64(b.failures)/float64(b.count) >= b.cfg.FailureRate {
+			b.open()
+		}
+	}
+}
+
+// skip releases the probe taken by a message that was not handled, e.g. as it
+// could not be decoded. The breaker closes if every other probe already
+// succeeded, and otherwise messages waiting for a probe are woken up.
+func (b *breaker) skip() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerHalfOpen || b.probes <= b.successes {
+		return
+	}
+	b.probes--
+	if b.successes > 0 && b.successes >= b.probes {
+		b.reset()
+		b.transition(BreakerClosed)
+		return
+	}
+	b.wake()
+}
+
+// wait blocks while the breaker is open
+func (b *breaker) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		state, changed := b.state, b.changed
+		b.mu.Unlock()
+		if state != BreakerOpen {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// open opens the breaker and schedules probing after the open timeout. The
+// lock must be held.
+func (b *breaker) open() {
+	b.reset()
+	b.transition(BreakerOpen)
+	time.AfterFunc(b.cfg.OpenTimeout, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.state == BreakerOpen {
+			b.transition(BreakerHalfOpen)
+		}
+	})
+}
+
+// reset clears the results and probes. The lock must be held.
+func (b *breaker) reset() {
+	clear(b.results)
+	b.next, b.count, b.failures = 0, 0, 0
+	b.probes, b.successes = 0, 0
+}
+
+// transition changes the state and wakes up the waiters. The lock must be held.
+func (b *breaker) transition(state BreakerState) {
+	b.state = state
+	b.wake()
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
+
+// wake wakes up the waiters so that they check the breaker again. The lock
+// must be held.
+func (b *breaker) wake() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// breakerChanged logs and records a change of the circuit breaker state
+func (s *Subscriber) breakerChanged(state BreakerState) {
+	switch state {
+	case BreakerOpen:
+		s.logger.Warn("Circuit breaker opened, pausing fetching", "open_timeout", s.breaker.cfg.OpenTimeout)
+	case BreakerHalfOpen:
+		s.logger.Info("Circuit breaker half-open, probing", "probes", s.breaker.cfg.Probes)
+	case BreakerClosed:
+		s.logger.Info("Circuit breaker closed, resuming fetching")
+	}
+	s.metrics.observeBreakerTransition(s.streamName, state)
+}
+
+// recordResult records the result of a handler call with the circuit breaker.
+// A message being processed by another worker says nothing about the handler.
+func (s *Subscriber) recordResult(err error) {
+	if errors.Is(err, errInProgress) {
+		s.breaker.skip()
+		return
+	}
+	s.breaker.record(err != nil)
+}
+
+// admitPulled decides whether to dispatch a pulled message. While half-open
+// with every probe taken it waits for the breaker to close or open again.
+// Once the breaker is open the message is nak'ed with the open timeout as
+// delay, and errBreakerOpen is returned to stop fetching.
+func (s *Subscriber) admitPulled(ctx context.Context, msg jetstream.Msg) (bool, error) {
+	for {
+		ok, state, changed := s.breaker.admit()
+		switch {
+		case ok:
+			return true, nil
+		case state == BreakerOpen:
+			s.settleAfter(s.logger.With(messageAttrs(msg)...), msg, outcomeNak, s.breaker.cfg.OpenTimeout)
+			return false, errBreakerOpen
+		}
+		select {
+		case <-ctx.Done():
+			s.settle(s.logger.With(messageAttrs(msg)...), msg, outcomeNak)
+			return false, nil
+		case <-changed:
+		}
+	}
+}
+
+// admitPushed decides whether to dispatch a pushed message. While the breaker
+// is open, or half-open with every probe taken, it blocks delivery until the
+// message is admitted, letting flow control pause the server rather than
+// using up delivery attempts. The message is nak'ed if shutdown starts first.
+func (s *Subscriber) admitPushed(ctx context.Context, msg jetstream.Msg) bool {
+	for {
+		ok, _, changed := s.breaker.admit()
+		if ok {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			s.settle(s.logger.With(messageAttrs(msg)...), msg, outcomeNak)
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// nakBuffered drains the iterator and naks the messages it had buffered with
+// the open timeout as delay, so they are not redelivered before probing starts
+func (s *Subscriber) nakBuffered(it jetstream.MessagesContext) {
+	it.Drain()
+	for {
+		msg, err := it.Next()
+		if err != nil {
+			return
+		}
+		s.settleAfter(s.logger.With(messageAttrs(msg)...), msg, outcomeNak, s.breaker.cfg.OpenTimeout)
+	}
+}
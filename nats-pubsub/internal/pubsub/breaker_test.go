@@ -0,0 +1,236 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newTestBreaker(cfg BreakerConfig) (*breaker, *[]BreakerState) {
+	var states []BreakerState
+	b := newBreaker(cfg, func(state BreakerState) {
+		states = append(states, state)
+	})
+	return b, &states
+}
+
+// transitions returns the states recorded so far, under the lock the breaker
+// holds while recording them
+func transitions(b *breaker, states *[]BreakerState) []BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BreakerState(nil), *states...)
+}
+
+// halfOpen opens the breaker and waits for it to turn half-open
+func halfOpen(t *testing.T, b *breaker) {
+	t.Helper()
+	b.mu.Lock()
+	b.open()
+	b.mu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for b.State() != BreakerHalfOpen {
+		if time.Now().After(deadline) {
+			t.Fatalf("breaker did not turn half-open, state %s", b.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBreakerOpensOnFailureRate(t *testing.T) {
+	b, states := newTestBreaker(BreakerConfig{FailureRate: 0.5, Window: 4, MinCalls: 4, OpenTimeout: time.Hour})
+
+	for _, failed := range []bool{true, false, true} {
+		b.record(failed)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state before min calls = %s, want %s", got, BreakerClosed)
+	}
+	b.record(false)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("state = %s, want %s", got, BreakerOpen)
+	}
+	if got := transitions(b, states); len(got) != 1 || got[0] != BreakerOpen {
+		t.Fatalf("transitions = %v, want [%s]", got, BreakerOpen)
+	}
+	if ok, _, _ := b.admit(); ok {
+		t.Fatal("open breaker admitted a message")
+	}
+}
+
+func TestBreakerWindowForgetsOldResults(t *testing.T) {
+	b, _ := newTestBreaker(BreakerConfig{FailureRate: 0.5, Window: 4, MinCalls: 4, OpenTimeout: time.Hour})
+
+	for _, failed := range []bool{true, false, false, false, false, true} {
+		b.record(failed)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state = %s, want %s", got, BreakerClosed)
+	}
+	b.record(true)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("state = %s, want %s", got, BreakerOpen)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []bool
+		want    BreakerState
+	}{
+		{"all probes succeed", []bool{false, false, false}, BreakerClosed},
+		{"probe fails", []bool{false, true}, BreakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, states := newTestBreaker(BreakerConfig{OpenTimeout: time.Millisecond, Probes: 3})
+			halfOpen(t, b)
+
+			for range 3 {
+				if ok, _, _ := b.admit(); !ok {
+					t.Fatal("half-open breaker did not admit a probe")
+				}
+			}
+			if ok, _, _ := b.admit(); ok {
+				t.Fatal("half-open breaker admitted more probes than configured")
+			}
+			for _, failed := range tt.results {
+				b.record(failed)
+			}
+			// The breaker opened and turned half-open before probing
+			if got := transitions(b, states); len(got) < 3 || got[2] != tt.want {
+				t.Fatalf("transitions = %v, want %s after probing", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerSkip(t *testing.T) {
+	t.Run("wakes waiters", func(t *testing.T) {
+		b, _ := newTestBreaker(BreakerConfig{OpenTimeout: time.Millisecond, Probes: 2})
+		halfOpen(t, b)
+
+		b.admit()
+		b.admit()
+		ok, _, changed := b.admit()
+		if ok {
+			t.Fatal("half-open breaker admitted more probes than configured")
+		}
+		b.skip()
+		select {
+		case <-changed:
+		default:
+			t.Fatal("skip did not wake the waiters")
+		}
+		if ok, _, _ := b.admit(); !ok {
+			t.Fatal("skipped probe was not released")
+		}
+	})
+
+	t.Run("closes once the other probes succeeded", func(t *testing.T) {
+		b, states := newTestBreaker(BreakerConfig{OpenTimeout: time.Millisecond, Probes: 3})
+		halfOpen(t, b)
+
+		for range 3 {
+			b.admit()
+		}
+		b.record(false)
+		b.record(false)
+		b.skip()
+		if got := b.State(); got != BreakerClosed {
+			t.Fatalf("state = %s, want %s", got, BreakerClosed)
+		}
+		if got := transitions(b, states); got[len(got)-1] != BreakerClosed {
+			t.Fatalf("transitions = %v, want %s last", got, BreakerClosed)
+		}
+	})
+
+	t.Run("stays half-open without successes", func(t *testing.T) {
+		b, _ := newTestBreaker(BreakerConfig{OpenTimeout: time.Millisecond, Probes: 1})
+		halfOpen(t, b)
+
+		b.admit()
+		b.skip()
+		if got := b.State(); got != BreakerHalfOpen {
+			t.Fatalf("state = %s, want %s", got, BreakerHalfOpen)
+		}
+	})
+
+	t.Run("ignored while closed", func(t *testing.T) {
+		b, states := newTestBreaker(BreakerConfig{})
+		b.skip()
+		if got := transitions(b, states); b.State() != BreakerClosed || len(got) != 0 {
+			t.Fatalf("state = %s with transitions %v, want %s without any", b.State(), got, BreakerClosed)
+		}
+	})
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *breaker
+	if ok, state, _ := b.admit(); !ok || state != BreakerClosed {
+		t.Fatalf("admit() = %v, %s, want true, %s", ok, state, BreakerClosed)
+	}
+	b.record(true)
+	b.skip()
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state = %s, want %s", got, BreakerClosed)
+	}
+}
+
+// nakMsg is a test message recording whether it was nak'ed
+type nakMsg struct {
+	*testMsg
+	naked chan struct{}
+}
+
+func (m *nakMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return nil, errors.New("no metadata")
+}
+
+func (m *nakMsg) Nak() error {
+	close(m.naked)
+	return nil
+}
+
+func TestAdmitPushedBlocksWhileOpen(t *testing.T) {
+	b, _ := newTestBreaker(BreakerConfig{OpenTimeout: 50 * time.Millisecond, Probes: 1})
+	s := &Subscriber{options: options{logger: slog.Default()}, breaker: b}
+	b.mu.Lock()
+	b.open()
+	b.mu.Unlock()
+
+	start := time.Now()
+	if !s.admitPushed(context.Background(), newTestMsg("ORDERS.received", "")) {
+		t.Fatal("message not admitted once the breaker turned half-open")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("admitted after %s, want delivery blocked for the open timeout", elapsed)
+	}
+
+	// With the only probe taken, delivery blocks until shutdown naks the message
+	ctx, cancel := context.WithCancel(context.Background())
+	msg := &nakMsg{testMsg: newTestMsg("ORDERS.received", ""), naked: make(chan struct{})}
+	done := make(chan bool, 1)
+	go func() { done <- s.admitPushed(ctx, msg) }()
+
+	select {
+	case <-done:
+		t.Fatal("message admitted with every probe taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	if <-done {
+		t.Error("message admitted after shutdown")
+	}
+	select {
+	case <-msg.naked:
+	default:
+		t.Error("message not nak'ed on shutdown")
+	}
+}
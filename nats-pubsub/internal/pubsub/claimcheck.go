@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+// ClaimCheckHeader is the message header naming the object holding the payload
+// of a claim-checked message
+const ClaimCheckHeader = "Claim-Check"
+
+// claimDeleteTimeout bounds the deletion of an object after its message is acked
+const claimDeleteTimeout = 5 * time.Second
+
+// claimCheck stores the payload of an outgoing message in the object store and
+// replaces it with a reference, if it reaches the threshold or would exceed the
+// server's max payload. It returns the name of the stored object, empty if the
+// payload was left in place.
+func (p *Publisher) claimCheck(ctx context.Context, msg *nats.Msg) (string, error) {
+	if p.objects == nil {
+		return "", nil
+	}
+	reached := p.claimThreshold > 0 && len(msg.Data) >= p.claimThreshold
+	maxPayload := p.js.Conn().MaxPayload()
+	if !reached && (maxPayload <= 0 || int64(msg.Size()) <= maxPayload) {
+		return "", nil
+	}
+
+	name := nuid.Next()
+	if _, err := p.objects.PutBytes(ctx, name, msg.Data); err != nil {
+		return "", fmt.Errorf("error storing payload: %w", err)
+	}
+	p.metrics.observeClaimCheck(msg.Subject, "publish")
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(ClaimCheckHeader, name)
+	msg.Data = nil
+	return name, nil
+}
+
+// discardClaim deletes the object stored for a message that failed to publish,
+// which no subscriber will ever fetch. Failures are only logged, the object
+// then expires with its TTL.
+func (p *Publisher) discardClaim(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), claimDeleteTimeout)
+	defer cancel()
+	if err := p.objects.Delete(ctx, name); err != nil {
+		p.logger.Warn("Error deleting claim-checked payload", "object", name, "error", err)
+	}
+}
+
+// claim fetches the payload of a claim-checked message from the object store.
+// Messages without a reference, or consumed without an object store, are
+// passed on as they are.
+func (s *Subscriber) claim(ctx context.Context, msg jetstream.Msg) (jetstream.Msg, error) {
+	name := msg.Headers().Get(ClaimCheckHeader)
+	if name == "" || s.objects == nil {
+		return msg, nil
+	}
+
+	data, err := s.objects.GetBytes(ctx, name)
+	if err != nil {
+		// A missing object will not reappear, other errors may be transient
+		if errors.Is(err, jetstream.ErrObjectNotFound) {
+			return nil, fmt.Errorf("error fetching payload %s: %w", name, err)
+		}
+		return nil, retryable(fmt.Errorf("error fetching payload %s: %w", name, err))
+	}
+	s.metrics.observeClaimCheck(msg.Subject(), "consume")
+	return withPayload(msg, data, ClaimCheckHeader), nil
+}
+
+// ack acknowledges a message. Claim-checked messages whose object is deleted
+// afterwards wait for the server to confirm the ack, so the object is never
+// deleted while the message can still be redelivered.
+func (s *Subscriber) ack(msg jetstream.Msg) error {
+	if !s.claimDelete || s.objects == nil || unwrap(msg).Headers().Get(ClaimCheckHeader) == "" {
+		return msg.Ack()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), claimDeleteTimeout)
+	defer cancel()
+	return msg.DoubleAck(ctx)
+}
+
+// release deletes the object of a claim-checked message once it is acked, if
+// enabled. Failures are only logged, the object then expires with its TTL.
+func (s *Subscriber) release(msg jetstream.Msg) {
+	name := unwrap(msg).Headers().Get(ClaimCheckHeader)
+	if name == "" || s.objects == nil || !s.claimDelete {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), claimDeleteTimeout)
+	defer cancel()
+	if err := s.objects.Delete(ctx, name); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+		s.logger.Warn("Error deleting claim-checked payload", append(messageAttrs(msg), "object", name, "error", err)...)
+	}
+}
+
+// retryableError marks a failure to prepare a message that may succeed on
+// redelivery, so the message is nak'ed rather than terminated
+type retryableError struct {
+	err error
+}
+
+func retryable(err error) error {
+	return &retryableError{err: err}
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// rejectOutcome returns how to settle a message that could not be prepared
+func rejectOutcome(err error) string {
+	var r *retryableError
+	if errors.As(err, &r) {
+		return outcomeNak
+	}
+	return outcomeTerm
+}
@@ -0,0 +1,188 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeObjectStore is an in-memory object store. Reads fail with getErr when
+// it is set.
+type fakeObjectStore struct {
+	jetstream.ObjectStore
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	getErr  error
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{objects: make(map[string][]byte)}
+}
+
+func (o *fakeObjectStore) PutBytes(ctx context.Context, name string, data []byte) (*jetstream.ObjectInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.objects[name] = data
+	return &jetstream.ObjectInfo{ObjectMeta: jetstream.ObjectMeta{Name: name}, Size: uint64(len(data))}, nil
+}
+
+func (o *fakeObjectStore) GetBytes(ctx context.Context, name string, opts ...jetstream.GetObjectOpt) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.getErr != nil {
+		return nil, o.getErr
+	}
+	data, ok := o.objects[name]
+	if !ok {
+		return nil, jetstream.ErrObjectNotFound
+	}
+	return data, nil
+}
+
+func (o *fakeObjectStore) Delete(ctx context.Context, name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.objects[name]; !ok {
+		return jetstream.ErrObjectNotFound
+	}
+	delete(o.objects, name)
+	return nil
+}
+
+func (o *fakeObjectStore) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.objects)
+}
+
+func TestPublishClaimCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		claimed bool
+	}{
+		{"below threshold", "small", false},
+		{"at threshold", "0123456789", true},
+		{"above threshold", "0123456789abcdef", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js := &fakeJetStream{}
+			store := newFakeObjectStore()
+			p := NewPublisher(js, "ORDERS.received", WithClaimCheck(store, 10))
+
+			if err := p.PublishMsg(context.Background(), &nats.Msg{Subject: "ORDERS.received", Data: []byte(tt.payload)}); err != nil {
+				t.Fatalf("PublishMsg error = %v", err)
+			}
+			if len(js.msgs) != 1 {
+				t.Fatalf("published %d messages, want 1", len(js.msgs))
+			}
+			msg := js.msgs[0]
+			name := msg.Header.Get(ClaimCheckHeader)
+			if !tt.claimed {
+				if name != "" || string(msg.Data) != tt.payload || store.len() != 0 {
+					t.Errorf("message claim-checked as %q, want the payload left in place", name)
+				}
+				return
+			}
+			if name == "" || len(msg.Data) != 0 {
+				t.Fatalf("message carries %d bytes and reference %q, want only a reference", len(msg.Data), name)
+			}
+			if data, err := store.GetBytes(context.Background(), name); err != nil || string(data) != tt.payload {
+				t.Errorf("stored payload = %q, %v, want %q", data, err, tt.payload)
+			}
+		})
+	}
+}
+
+func TestPublishClaimCheckDeletesOnFailure(t *testing.T) {
+	js := &fakeJetStream{pubErr: errors.New("no responders")}
+	store := newFakeObjectStore()
+	p := NewPublisher(js, "ORDERS.received", WithClaimCheck(store, 1))
+
+	if err := p.PublishMsg(context.Background(), &nats.Msg{Subject: "ORDERS.received", Data: []byte("payload")}); err == nil {
+		t.Fatal("PublishMsg succeeded, want an error")
+	}
+	if n := store.len(); n != 0 {
+		t.Errorf("%d objects left after the failed publish, want 0", n)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	store := newFakeObjectStore()
+	store.objects["obj-1"] = []byte("payload")
+	s := &Subscriber{options: options{objects: store}}
+
+	msg := newTestMsg("ORDERS.received", "")
+	msg.headers.Set(ClaimCheckHeader, "obj-1")
+	msg.headers.Set("Trace", "1")
+	got, err := s.claim(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("claim error = %v", err)
+	}
+	if string(got.Data()) != "payload" {
+		t.Errorf("payload = %q, want %q", got.Data(), "payload")
+	}
+	if got.Headers().Get(ClaimCheckHeader) != "" || got.Headers().Get("Trace") != "1" {
+		t.Errorf("headers = %v, want the reference dropped and the rest kept", got.Headers())
+	}
+
+	// Messages without a reference are passed on as they are
+	plain := newTestMsg("ORDERS.received", "inline")
+	if got, err := s.claim(context.Background(), plain); err != nil || got != jetstream.Msg(plain) {
+		t.Errorf("claim = %v, %v, want the message unchanged", got, err)
+	}
+}
+
+func TestClaimFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		getErr error
+		want   string
+	}{
+		{"object missing", nil, outcomeTerm},
+		{"store unavailable", errors.New("timeout"), outcomeNak},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeObjectStore()
+			store.getErr = tt.getErr
+			s := &Subscriber{options: options{objects: store}}
+
+			msg := newTestMsg("ORDERS.received", "")
+			msg.headers.Set(ClaimCheckHeader, "obj-1")
+			_, err := s.claim(context.Background(), msg)
+			if err == nil {
+				t.Fatal("claim succeeded, want an error")
+			}
+			if got := rejectOutcome(err); got != tt.want {
+				t.Errorf("outcome = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleaseDeletesObject(t *testing.T) {
+	store := newFakeObjectStore()
+	store.objects["obj-1"] = []byte("payload")
+	msg := newTestMsg("ORDERS.received", "")
+	msg.headers.Set(ClaimCheckHeader, "obj-1")
+
+	// Objects are kept unless deletion is enabled
+	s := &Subscriber{options: options{objects: store}}
+	s.release(withPayload(msg, []byte("payload"), ClaimCheckHeader))
+	if n := store.len(); n != 1 {
+		t.Fatalf("%d objects left, want 1", n)
+	}
+
+	s.claimDelete = true
+	s.release(withPayload(msg, []byte("payload"), ClaimCheckHeader))
+	if n := store.len(); n != 0 {
+		t.Errorf("%d objects left, want 0", n)
+	}
+}
@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/compress"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// compress compresses the payload of an outgoing message if it reaches the
+// threshold, unless it is already compressed or would not shrink
+func (p *Publisher) compress(msg *nats.Msg) error {
+	if p.compressor == nil || len(msg.Data) < p.compressThreshold || msg.Header.Get(compress.EncodingHeader) != "" {
+		return nil
+	}
+
+	data, err := p.compressor.Compress(msg.Data)
+	if err != nil {
+		return fmt.Errorf("error compressing message: %w", err)
+	}
+	p.metrics.observeCompression(msg.Subject, p.compressor.Encoding(), "publish", len(data), len(msg.Data))
+	if len(data) >= len(msg.Data) {
+		return nil
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(compress.EncodingHeader, p.compressor.Encoding())
+	msg.Data = data
+	return nil
+}
+
+// decompressMsg returns the message with its payload decompressed according to
+// its encoding header, or the message itself if it is not compressed
+func decompressMsg(msg jetstream.Msg) (jetstream.Msg, error) {
+	encoding := msg.Headers().Get(compress.EncodingHeader)
+	if encoding == "" {
+		return msg, nil
+	}
+	c, err := compress.ByName(encoding)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Decompress(msg.Data())
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing message: %w", err)
+	}
+
+	// Handlers see the payload as it was before compression
+	return withPayload(msg, data, compress.EncodingHeader), nil
+}
+
+// decompress decompresses a consumed message, recording the compression ratio
+func (s *Subscriber) decompress(msg jetstream.Msg) (jetstream.Msg, error) {
+	out, err := decompressMsg(msg)
+	if err != nil || out == msg {
+		return out, err
+	}
+	s.metrics.observeCompression(msg.Subject(), msg.Headers().Get(compress.EncodingHeader), "consume", len(msg.Data()), len(out.Data()))
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/envelope"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// errUnencrypted is returned for plaintext messages on an encrypted subject
+var errUnencrypted = errors.New("unencrypted message on encrypted subject")
+
+// unknownKeyDelay is the redelivery delay of a message sealed with a key that is
+// not in the keyring yet
+const unknownKeyDelay = 30 * time.Second
+
+// encrypted reports whether messages on the subject must be encrypted
+func (o *options) encrypted(subject string) bool {
+	tokens := strings.Split(subject, ".")
+	for _, pattern := range o.encryptSubjects {
+		if matchSubject(pattern, tokens) {
+			return true
+		}
+	}
+	return false
+}
+
+// encrypt seals the payload of an outgoing message on an encrypted subject,
+// binding it to the subject so it cannot be replayed on another one
+func (p *Publisher) encrypt(msg *nats.Msg) error {
+	if p.keyring == nil || !p.encrypted(msg.Subject) {
+		return nil
+	}
+
+	env, err := p.keyring.Seal(msg.Data, []byte(msg.Subject))
+	if err != nil {
+		return fmt.Errorf("error encrypting message: %w", err)
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(envelope.KeyIDHeader, env.KeyID)
+	msg.Header.Set(envelope.DataKeyHeader, base64.StdEncoding.EncodeToString(env.DataKey))
+	msg.Data = env.Ciphertext
+	return nil
+}
+
+// decrypt opens the payload of a consumed message sealed by a publisher. Without
+// a keyring messages are passed on as they are. Messages sealed with an unknown
+// key are retried after a delay, as the key may be added to this subscriber's
+// keyring by a rollout in progress.
+func (s *Subscriber) decrypt(msg jetstream.Msg) (jetstream.Msg, error) {
+	if s.keyring == nil {
+		return msg, nil
+	}
+
+	keyID := msg.Headers().Get(envelope.KeyIDHeader)
+	if keyID == "" {
+		if s.encrypted(msg.Subject()) {
+			return nil, errUnencrypted
+		}
+		return msg, nil
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(msg.Headers().Get(envelope.DataKeyHeader))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding data key: %w", err)
+	}
+	data, err := s.keyring.Open(envelope.Envelope{
+		KeyID:      keyID,
+		DataKey:    dataKey,
+		Ciphertext: msg.Data(),
+	}, []byte(msg.Subject()))
+	if errors.Is(err, envelope.ErrUnknownKey) {
+		return nil, retryable(RetryAfter(unknownKeyDelay, err))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return withPayload(msg, data, envelope.KeyIDHeader, envelope.DataKeyHeader), nil
+}
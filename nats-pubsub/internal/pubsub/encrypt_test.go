@@ -0,0 +1,47 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/envelope"
+)
+
+func TestDecryptUnknownKeyIsRetried(t *testing.T) {
+	newer, err := envelope.NewKeyring("k2", map[string][]byte{"k2": bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, err := envelope.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := newer.Seal([]byte("payload"), []byte("ORDERS.received"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := newTestMsg("ORDERS.received", string(env.Ciphertext))
+	msg.headers.Set(envelope.KeyIDHeader, env.KeyID)
+	msg.headers.Set(envelope.DataKeyHeader, base64.StdEncoding.EncodeToString(env.DataKey))
+
+	s := &Subscriber{options: options{keyring: older}}
+	_, err = s.decrypt(msg)
+	if !errors.Is(err, envelope.ErrUnknownKey) {
+		t.Fatalf("decrypt error = %v, want %v", err, envelope.ErrUnknownKey)
+	}
+	if got := rejectOutcome(err); got != outcomeNak {
+		t.Errorf("outcome = %s, want %s", got, outcomeNak)
+	}
+	if got := retryDelay(err); got != unknownKeyDelay {
+		t.Errorf("delay = %s, want %s", got, unknownKeyDelay)
+	}
+
+	// Messages that cannot be decrypted with a known key are terminated
+	msg.headers.Set(envelope.KeyIDHeader, "k1")
+	if _, err = s.decrypt(msg); err == nil || rejectOutcome(err) != outcomeTerm {
+		t.Errorf("decrypt error = %v, want one terminating the message", err)
+	}
+}
@@ -0,0 +1,158 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/codec"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Handler processes a single message. Returning nil acknowledges the message,
+// returning an error naks it so that it is redelivered.
+type Handler func(ctx context.Context, msg jetstream.Msg) error
+
+// Middleware wraps a handler with behaviour such as deduplication
+type Middleware func(next Handler) Handler
+
+// chain wraps the handler with the middleware, the first being outermost
+func chain(h Handler, mw ...Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// RetryError asks for a failed message to be redelivered after a delay rather
+// than straight away
+type RetryError struct {
+	Delay time.Duration
+	Err   error
+}
+
+// RetryAfter returns an error naking the message with a redelivery delay
+func RetryAfter(delay time.Duration, err error) error {
+	return &RetryError{Delay: delay, Err: err}
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// retryDelay returns the redelivery delay requested by a handler error
+func retryDelay(err error) time.Duration {
+	var r *RetryError
+	if errors.As(err, &r) {
+		return r.Delay
+	}
+	return 0
+}
+
+type loggerKey struct{}
+
+// withLogger returns a copy of ctx carrying the logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger annotated with the attributes of the
+// message being processed, or slog.Default() outside of a handler
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// decodedMsg is a consumed message whose payload has been decrypted or
+// decompressed. Acknowledgements go to the original message.
+type decodedMsg struct {
+	jetstream.Msg
+	data    []byte
+	headers nats.Header
+}
+
+func (m *decodedMsg) Data() []byte {
+	return m.data
+}
+
+func (m *decodedMsg) Headers() nats.Header {
+	return m.headers
+}
+
+// withPayload returns the message with its payload replaced by data and the
+// headers describing the encoding removed
+func withPayload(msg jetstream.Msg, data []byte, drop ...string) jetstream.Msg {
+	headers := maps.Clone(msg.Headers())
+	for _, h := range drop {
+		headers.Del(h)
+	}
+	return &decodedMsg{Msg: msg, data: data, headers: headers}
+}
+
+// unwrap returns the message as it was consumed, before any payload decoding
+func unwrap(msg jetstream.Msg) jetstream.Msg {
+	for {
+		d, ok := msg.(*decodedMsg)
+		if !ok {
+			return msg
+		}
+		msg = d.Msg
+	}
+}
+
+// Decode unmarshals the message payload into v with the codec matching its
+// content type header, assuming JSON when the header is missing
+func Decode(msg jetstream.Msg, v any) error {
+	c, err := codec.ForContentType(msg.Headers().Get(codec.ContentTypeHeader))
+	if err != nil {
+		return err
+	}
+	if err := c.Unmarshal(msg.Data(), v); err != nil {
+		return fmt.Errorf("error unmarshaling message: %w", err)
+	}
+	return nil
+}
+
+// LogMessage is the default handler, decoding a Message and logging its content
+func LogMessage(ctx context.Context, msg jetstream.Msg) error {
+	var message Message
+	if err := Decode(msg, &message); err != nil {
+		return err
+	}
+
+	// Log message details
+	LoggerFromContext(ctx).Info("Received message",
+		"content", message.Content,
+		"timestamp", message.Timestamp,
+	)
+
+	// Simulate some processing time
+	// time.Sleep(100 * time.Millisecond)
+
+	return nil
+}
+
+// LogBatch is the batch counterpart of LogMessage, failing only the messages
+// that cannot be decoded
+func LogBatch(ctx context.Context, msgs []jetstream.Msg) error {
+	var batchErr BatchError
+	for i, msg := range msgs {
+		if err := LogMessage(ctx, msg); err != nil {
+			batchErr.Fail(i, err)
+		}
+	}
+	if len(batchErr.Failed) > 0 {
+		return &batchErr
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Idempotency defaults
+const (
+	DefaultIdempotencyTTL  = 24 * time.Hour   // how long processed IDs are remembered
+	DefaultClaimTimeout    = 30 * time.Second // how long a claim blocks other workers
+	DefaultInProgressDelay = time.Second      // redelivery delay of a message being processed elsewhere
+)
+
+// Values of the entries in the idempotency bucket. A claim records when
+// processing started, so that it can be taken over once it times out.
+const (
+	idempotencyDone        = "done"
+	idempotencyClaimPrefix = "processing:"
+)
+
+// idempotencyStoreTimeout bounds settling a claim after the handler returns
+const idempotencyStoreTimeout = 5 * time.Second
+
+var (
+	// errDuplicate reports an ID that was already processed
+	errDuplicate = errors.New("message already processed")
+	// errInProgress naks a message whose ID another worker is processing
+	errInProgress = errors.New("message is being processed by another worker")
+)
+
+// Idempotency skips messages whose ID was already processed, recording IDs in
+// a KV bucket. A worker claims an ID before running the handler and marks it
+// as done afterwards, so a duplicate arriving while the original is processed
+// is redelivered later instead of running concurrently. Claims left behind by
+// a crashed worker can be taken over once the claim timeout has passed.
+type Idempotency struct {
+	kv              jetstream.KeyValue
+	id              KeyFunc
+	claimTimeout    time.Duration
+	inProgressDelay time.Duration
+}
+
+// NewIdempotency opens or creates the bucket, remembering processed IDs for
+// the TTL. Message IDs are extracted by id, e.g. HeaderKey(jetstream.MsgIDHeader).
+func NewIdempotency(ctx context.Context, js jetstream.JetStream, bucket string, ttl time.Duration, id KeyFunc) (*Idempotency, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "Processed message IDs",
+		TTL:         ttl,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating idempotency bucket: %w", err)
+	}
+	return &Idempotency{
+		kv:              kv,
+		id:              id,
+		claimTimeout:    DefaultClaimTimeout,
+		inProgressDelay: DefaultInProgressDelay,
+	}, nil
+}
+
+// SetClaimTimeout sets how long a claim blocks other workers, which should
+// exceed the longest handler run
+func (i *Idempotency) SetClaimTimeout(d time.Duration) {
+	i.claimTimeout = d
+}
+
+// Middleware runs the handler at most once per message ID. Duplicates are
+// acked without running the handler, and messages whose ID is being processed
+// by another worker are nak'ed with a delay. Messages without an ID are
+// processed as usual.
+func (i *Idempotency) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg jetstream.Msg) error {
+		logger := LoggerFromContext(ctx)
+
+		id, err := i.id(msg)
+		if err != nil || id == "" {
+			logger.Warn("Message has no ID, processing without deduplication", "error", err)
+			return next(ctx, msg)
+		}
+		key := idempotencyKey(id)
+
+		rev, err := i.claim(ctx, key)
+		switch {
+		case errors.Is(err, errDuplicate):
+			logger.Info("Skipping duplicate message", "idempotency_id", id)
+			return nil
+		case errors.Is(err, errInProgress):
+			return RetryAfter(i.inProgressDelay, err)
+		case err != nil:
+			return err
+		}
+
+		// Settle the claim even if the handler's context is cancelled
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyStoreTimeout)
+		defer cancel()
+
+		if err := next(ctx, msg); err != nil {
+			// Release the claim so that the redelivered message is processed again
+			if delErr := i.kv.Delete(storeCtx, key, jetstream.LastRevision(rev)); delErr != nil {
+				logger.Warn("Error releasing idempotency claim", "idempotency_id", id, "error", delErr)
+			}
+			return err
+		}
+
+		// The message is acked even if marking fails, a duplicate then waits
+		// for the claim timeout and is processed again
+		if _, err := i.kv.Update(storeCtx, key, []byte(idempotencyDone), rev); err != nil {
+			logger.Warn("Error recording processed message", "idempotency_id", id, "error", err)
+		}
+		return nil
+	}
+}
+
+// claim records that the ID is being processed, returning the revision of the
+// claim. It fails with errDuplicate if the ID was processed and errInProgress
+// if it holds a claim that has not timed out.
+func (i *Idempotency) claim(ctx context.Context, key string) (uint64, error) {
+	value := []byte(idempotencyClaimPrefix + strconv.FormatInt(time.Now().UnixMilli(), 10))
+
+	rev, err := i.kv.Create(ctx, key, value)
+	if err == nil {
+		return rev, nil
+	}
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		return 0, fmt.Errorf("error claiming message ID: %w", err)
+	}
+
+	entry, err := i.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		// The claim was released in the meantime
+		return 0, errInProgress
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading message ID: %w", err)
+	}
+
+	current := string(entry.Value())
+	if current == idempotencyDone {
+		return 0, errDuplicate
+	}
+	claimedAt, err := strconv.ParseInt(strings.TrimPrefix(current, idempotencyClaimPrefix), 10, 64)
+	if err == nil && time.Since(time.UnixMilli(claimedAt)) < i.claimTimeout {
+		return 0, errInProgress
+	}
+
+	// Take over a timed out claim, unless another worker just did
+	rev, err = i.kv.Update(ctx, key, value, entry.Revision())
+	if err != nil {
+		return 0, errInProgress
+	}
+	return rev, nil
+}
+
+// idempotencyKey hashes a message ID into a valid KV key
+func idempotencyKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
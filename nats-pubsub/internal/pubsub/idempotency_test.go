@@ -0,0 +1,164 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// newTestIdempotency returns an idempotency store backed by kv, reading IDs
+// from the Nats-Msg-Id header
+func newTestIdempotency(t *testing.T, kv *fakeKV) *Idempotency {
+	t.Helper()
+	i, err := NewIdempotency(context.Background(), &fakeJetStream{kv: kv}, "PROCESSED", time.Hour, HeaderKey(jetstream.MsgIDHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.SetClaimTimeout(time.Minute)
+	return i
+}
+
+func claimValue(at time.Time) []byte {
+	return []byte(idempotencyClaimPrefix + strconv.FormatInt(at.UnixMilli(), 10))
+}
+
+func TestIdempotencyClaim(t *testing.T) {
+	key := idempotencyKey("msg-1")
+	tests := []struct {
+		name      string
+		stored    []byte
+		createErr error
+		wantErr   error
+		takeover  bool
+	}{
+		{"new ID", nil, nil, nil, false},
+		{"already done", []byte(idempotencyDone), nil, errDuplicate, false},
+		{"claim not timed out", claimValue(time.Now().Add(-time.Second)), nil, errInProgress, false},
+		{"claim timed out", claimValue(time.Now().Add(-time.Hour)), nil, nil, true},
+		{"malformed claim", []byte("processing:soon"), nil, nil, true},
+		{"claim released after create conflict", nil, jetstream.ErrKeyExists, errInProgress, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newFakeKV()
+			var storedRev uint64
+			if tt.stored != nil {
+				storedRev = kv.set(key, tt.stored)
+			}
+			kv.createErr = tt.createErr
+			i := newTestIdempotency(t, kv)
+
+			rev, err := i.claim(context.Background(), key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("claim error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if got := kv.value(key); tt.stored != nil && got != string(tt.stored) {
+					t.Errorf("stored value = %q, want it left as %q", got, tt.stored)
+				}
+				return
+			}
+			if rev == 0 || (tt.takeover && rev == storedRev) {
+				t.Errorf("claim revision = %d, want a new revision", rev)
+			}
+			if got := kv.value(key); len(got) <= len(idempotencyClaimPrefix) || got[:len(idempotencyClaimPrefix)] != idempotencyClaimPrefix {
+				t.Errorf("stored value = %q, want a claim", got)
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	key := idempotencyKey("msg-1")
+	handlerErr := errors.New("handler failed")
+	claimed := claimValue(time.Now())
+	tests := []struct {
+		name       string
+		stored     []byte
+		handlerErr error
+		wantCalled bool
+		wantErr    error
+		wantValue  string // empty if the key is deleted
+	}{
+		{"handler succeeds", nil, nil, true, nil, idempotencyDone},
+		{"handler fails", nil, handlerErr, true, handlerErr, ""},
+		{"duplicate", []byte(idempotencyDone), nil, false, nil, idempotencyDone},
+		{"in progress elsewhere", claimed, nil, false, errInProgress, string(claimed)},
+		{"takes over timed out claim", claimValue(time.Now().Add(-time.Hour)), nil, true, nil, idempotencyDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newFakeKV()
+			if tt.stored != nil {
+				kv.set(key, tt.stored)
+			}
+			i := newTestIdempotency(t, kv)
+
+			called := false
+			handler := i.Middleware(func(ctx context.Context, msg jetstream.Msg) error {
+				called = true
+				return tt.handlerErr
+			})
+			msg := newTestMsg("ORDERS.received", "")
+			msg.headers.Set(jetstream.MsgIDHeader, "msg-1")
+
+			err := handler(context.Background(), msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("handler error = %v, want %v", err, tt.wantErr)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			if got := kv.value(key); got != tt.wantValue {
+				t.Errorf("stored value = %q, want %q", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestIdempotencyReleasesClaimOnError(t *testing.T) {
+	kv := newFakeKV()
+	i := newTestIdempotency(t, kv)
+	handler := i.Middleware(func(ctx context.Context, msg jetstream.Msg) error {
+		return errors.New("handler failed")
+	})
+	msg := newTestMsg("ORDERS.received", "")
+	msg.headers.Set(jetstream.MsgIDHeader, "msg-1")
+
+	if err := handler(context.Background(), msg); err == nil {
+		t.Fatal("handler succeeded, want the handler's error")
+	}
+	key := idempotencyKey("msg-1")
+	if len(kv.deleted) != 1 || kv.deleted[0] != key {
+		t.Errorf("deleted keys = %v, want the claim released", kv.deleted)
+	}
+
+	// The redelivered message is processed again
+	called := false
+	handler = i.Middleware(func(ctx context.Context, msg jetstream.Msg) error {
+		called = true
+		return nil
+	})
+	if err := handler(context.Background(), msg); err != nil || !called {
+		t.Errorf("redelivery = %v with handler called %v, want it processed", err, called)
+	}
+}
+
+func TestIdempotencyInProgressDelay(t *testing.T) {
+	kv := newFakeKV()
+	kv.set(idempotencyKey("msg-1"), claimValue(time.Now()))
+	i := newTestIdempotency(t, kv)
+
+	handler := i.Middleware(func(ctx context.Context, msg jetstream.Msg) error { return nil })
+	msg := newTestMsg("ORDERS.received", "")
+	msg.headers.Set(jetstream.MsgIDHeader, "msg-1")
+
+	err := handler(context.Background(), msg)
+	if got := retryDelay(err); got != DefaultInProgressDelay {
+		t.Errorf("delay = %s, want %s", got, DefaultInProgressDelay)
+	}
+}
@@ -0,0 +1,186 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// KeyFunc extracts the ordering key of a message. Messages with the same key
+// are processed by the same worker, in the order they were fetched.
+type KeyFunc func(msg jetstream.Msg) (string, error)
+
+// Subject keys messages by their whole subject
+func Subject(msg jetstream.Msg) (string, error) {
+	return msg.Subject(), nil
+}
+
+// SubjectToken keys messages by the subject token at index i, counting from
+// the end when i is negative
+func SubjectToken(i int) KeyFunc {
+	return func(msg jetstream.Msg) (string, error) {
+		tokens := strings.Split(msg.Subject(), ".")
+		idx := i
+		if idx < 0 {
+			idx += len(tokens)
+		}
+		if idx < 0 || idx >= len(tokens) {
+			return "", fmt.Errorf("subject %s has no token %d", msg.Subject(), i)
+		}
+		return tokens[idx], nil
+	}
+}
+
+// HeaderKey keys messages by the value of a header
+func HeaderKey(name string) KeyFunc {
+	return func(msg jetstream.Msg) (string, error) {
+		v := msg.Headers().Get(name)
+		if v == "" {
+			return "", fmt.Errorf("header %s not set", name)
+		}
+		return v, nil
+	}
+}
+
+// JSONField keys messages by a field of their JSON payload, given as a dotted
+// path such as customer.id. Compressed payloads are decompressed first, but
+// keys are extracted before claim checks are resolved and payloads decrypted,
+// so JSON keys cannot be combined with either.
+func JSONField(path string) KeyFunc {
+	fields := strings.Split(path, ".")
+	return func(msg jetstream.Msg) (string, error) {
+		msg, err := decompressMsg(msg)
+		if err != nil {
+			return "", err
+		}
+		dec := json.NewDecoder(bytes.NewReader(msg.Data()))
+		dec.UseNumber()
+
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return "", fmt.Errorf("error unmarshaling message: %w", err)
+		}
+		for _, f := range fields {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("field %s not found", path)
+			}
+			if v, ok = obj[f]; !ok {
+				return "", fmt.Errorf("field %s not found", path)
+			}
+		}
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(v), nil
+	}
+}
+
+// ParseKeyFunc parses a key spec of the form subject, subject:<index>,
+// header:<name> or json:<path>
+func ParseKeyFunc(spec string) (KeyFunc, error) {
+	if spec == "subject" {
+		return Subject, nil
+	}
+	source, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid key spec %q", spec)
+	}
+
+	switch source {
+	case "subject":
+		i, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject token index %q: %w", arg, err)
+		}
+		return SubjectToken(i), nil
+	case "header":
+		return HeaderKey(arg), nil
+	case "json":
+		return JSONField(arg), nil
+	default:
+		return nil, fmt.Errorf("unknown key source %q", source)
+	}
+}
+
+// dispatcher routes fetched messages to the worker queues. Without a key
+// function all workers share one queue, otherwise each worker has its own and
+// keys are pinned to workers by hash.
+type dispatcher struct {
+	queues  []chan jetstream.Msg
+	keyFunc KeyFunc
+	counts  []uint64 // messages routed to each worker, only used by the fetching goroutine
+}
+
+// newDispatcher creates the worker queues. Each queue can hold one message per
+// slot so that dispatching a message for which a slot was claimed never blocks.
+func (s *Subscriber) newDispatcher(maxWorkers, capacity int) *dispatcher {
+	d := &dispatcher{keyFunc: s.keyFunc}
+	if s.keyFunc == nil {
+		d.queues = []chan jetstream.Msg{make(chan jetstream.Msg, capacity)}
+		return d
+	}
+
+	d.queues = make([]chan jetstream.Msg, maxWorkers)
+	for i := range d.queues {
+		d.queues[i] = make(chan jetstream.Msg, capacity)
+	}
+	d.counts = make([]uint64, maxWorkers)
+	return d
+}
+
+// queue returns the queue read by the worker at index i
+func (d *dispatcher) queue(i int) <-chan jetstream.Msg {
+	return d.queues[i%len(d.queues)]
+}
+
+// close closes every queue, letting the workers finish once they are empty
+func (d *dispatcher) close() {
+	for _, q := range d.queues {
+		close(q)
+	}
+}
+
+// dispatch hands a message to the worker owning its key. Messages whose key
+// cannot be extracted share the empty key.
+func (s *Subscriber) dispatch(d *dispatcher, msg jetstream.Msg) {
+	if d.keyFunc == nil {
+		d.queues[0] <- msg
+		s.metrics.addInFlight(s.streamName, 1)
+		return
+	}
+
+	key, err := d.keyFunc(msg)
+	if err != nil {
+		s.logger.Warn("Error extracting message key, using the empty key", append(messageAttrs(msg), "error", err)...)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	i := int(h.Sum32() % uint32(len(d.queues)))
+
+	d.queues[i] <- msg
+	s.metrics.addInFlight(s.streamName, 1)
+
+	d.counts[i]++
+	s.metrics.observeKeyed(s.streamName, i+1, skew(d.counts))
+}
+
+// skew returns how many more messages the busiest worker received than the
+// average, 1 meaning keys are evenly spread
+func skew(counts []uint64) float64 {
+	var total, busiest uint64
+	for _, c := range counts {
+		total += c
+		busiest = max(busiest, c)
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(busiest) * float64(len(counts)) / float64(total)
+}
@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/compress"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// testMsg is a message with a subject, headers and payload, and nothing else
+type testMsg struct {
+	jetstream.Msg
+	subject string
+	headers nats.Header
+	data    []byte
+}
+
+func (m *testMsg) Subject() string      { return m.subject }
+func (m *testMsg) Headers() nats.Header { return m.headers }
+func (m *testMsg) Data() []byte         { return m.data }
+
+func newTestMsg(subject, data string) *testMsg {
+	return &testMsg{subject: subject, headers: nats.Header{}, data: []byte(data)}
+}
+
+func TestParseKeyFunc(t *testing.T) {
+	msg := newTestMsg("ORDERS.eu.received", `{"customer":{"id":42},"region":"eu"}`)
+	msg.headers.Set("Tenant", "acme")
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"subject", "ORDERS.eu.received"},
+		{"subject:1", "eu"},
+		{"subject:-1", "received"},
+		{"header:Tenant", "acme"},
+		{"json:region", "eu"},
+		{"json:customer.id", "42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			key, err := ParseKeyFunc(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseKeyFunc(%q) error = %v", tt.spec, err)
+			}
+			got, err := key(msg)
+			if err != nil {
+				t.Fatalf("key error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeyFuncInvalid(t *testing.T) {
+	for _, spec := range []string{"", "header", "header:", "subject:x", "body:id"} {
+		if _, err := ParseKeyFunc(spec); err == nil {
+			t.Errorf("ParseKeyFunc(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestKeyFuncMissing(t *testing.T) {
+	msg := newTestMsg("ORDERS.received", `{"customer":"c1"}`)
+
+	tests := []struct {
+		name string
+		key  KeyFunc
+	}{
+		{"subject token out of range", SubjectToken(5)},
+		{"header not set", HeaderKey("Tenant")},
+		{"field not found", JSONField("region")},
+		{"path through a scalar", JSONField("customer.id")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key, err := tt.key(msg); err == nil {
+				t.Errorf("key = %q, want an error", key)
+			}
+		})
+	}
+}
+
+func TestJSONFieldInvalidPayload(t *testing.T) {
+	if key, err := JSONField("id")(newTestMsg("ORDERS.received", "not json")); err == nil {
+		t.Errorf("key = %q, want an error", key)
+	}
+}
+
+func TestJSONFieldCompressed(t *testing.T) {
+	data, err := compress.Zstd.Compress([]byte(`{"id":"o-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &testMsg{subject: "ORDERS.received", headers: nats.Header{}, data: data}
+	msg.headers.Set(compress.EncodingHeader, compress.Zstd.Encoding())
+
+	got, err := JSONField("id")(msg)
+	if err != nil {
+		t.Fatalf("key error = %v", err)
+	}
+	if got != "o-1" {
+		t.Errorf("key = %q, want %q", got, "o-1")
+	}
+}
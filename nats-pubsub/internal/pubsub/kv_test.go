@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeJetStream hands out a single fake KV bucket and records published
+// messages, failing them with pubErr when it is set
+type fakeJetStream struct {
+	jetstream.JetStream
+	kv *fakeKV
+
+	msgs   []*nats.Msg
+	pubErr error
+}
+
+// Conn returns a connection that has never learned the server's max payload
+func (js *fakeJetStream) Conn() *nats.Conn {
+	return &nats.Conn{}
+}
+
+func (js *fakeJetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	if js.pubErr != nil {
+		return nil, js.pubErr
+	}
+	js.msgs = append(js.msgs, msg)
+	return &jetstream.PubAck{Sequence: uint64(len(js.msgs))}, nil
+}
+
+func (js *fakeJetStream) CreateOrUpdateKeyValue(ctx context.Context, cfg jetstream.KeyValueConfig) (jetstream.KeyValue, error) {
+	return js.kv, nil
+}
+
+// fakeKV is an in-memory KV bucket. Reads fail with getErr and creates with
+// createErr when they are set. Deleted keys are recorded in deleted.
+type fakeKV struct {
+	jetstream.KeyValue
+
+	mu        sync.Mutex
+	entries   map[string]*fakeEntry
+	rev       uint64
+	getErr    error
+	createErr error
+	deleted   []string
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{entries: make(map[string]*fakeEntry)}
+}
+
+// set stores a value, returning its revision
+func (kv *fakeKV) set(key string, value []byte) uint64 {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.rev++
+	kv.entries[key] = &fakeEntry{key: key, value: value, rev: kv.rev}
+	return kv.rev
+}
+
+func (kv *fakeKV) Get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.getErr != nil {
+		return nil, kv.getErr
+	}
+	e, ok := kv.entries[key]
+	if !ok {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return e, nil
+}
+
+func (kv *fakeKV) Create(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (uint64, error) {
+	kv.mu.Lock()
+	if kv.createErr != nil {
+		kv.mu.Unlock()
+		return 0, kv.createErr
+	}
+	_, ok := kv.entries[key]
+	kv.mu.Unlock()
+	if ok {
+		return 0, jetstream.ErrKeyExists
+	}
+	return kv.set(key, value), nil
+}
+
+// Update stores a value if the key is at the given revision
+func (kv *fakeKV) Update(ctx context.Context, key string, value []byte, last uint64) (uint64, error) {
+	kv.mu.Lock()
+	e, ok := kv.entries[key]
+	kv.mu.Unlock()
+	if !ok || e.rev != last {
+		return 0, errors.New("wrong last sequence")
+	}
+	return kv.set(key, value), nil
+}
+
+// Delete removes a key regardless of the revision in opts
+func (kv *fakeKV) Delete(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.entries, key)
+	kv.deleted = append(kv.deleted, key)
+	return nil
+}
+
+// value returns the stored value of a key, empty if there is none
+func (kv *fakeKV) value(key string) string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if e, ok := kv.entries[key]; ok {
+		return string(e.value)
+	}
+	return ""
+}
+
+// WatchAll delivers no existing entries and no updates
+func (kv *fakeKV) WatchAll(ctx context.Context, opts ...jetstream.WatchOpt) (jetstream.KeyWatcher, error) {
+	w := &fakeWatcher{updates: make(chan jetstream.KeyValueEntry, 1)}
+	w.updates <- nil
+	return w, nil
+}
+
+type fakeWatcher struct {
+	jetstream.KeyWatcher
+	updates chan jetstream.KeyValueEntry
+	once    sync.Once
+}
+
+func (w *fakeWatcher) Updates() <-chan jetstream.KeyValueEntry { return w.updates }
+
+func (w *fakeWatcher) Stop() error {
+	w.once.Do(func() { close(w.updates) })
+	return nil
+}
+
+type fakeEntry struct {
+	jetstream.KeyValueEntry
+	key   string
+	value []byte
+	rev   uint64
+}
+
+func (e *fakeEntry) Key() string                     { return e.key }
+func (e *fakeEntry) Value() []byte                   { return e.value }
+func (e *fakeEntry) Revision() uint64                { return e.rev }
+func (e *fakeEntry) Operation() jetstream.KeyValueOp { return jetstream.KeyValuePut }
@@ -0,0 +1,305 @@
+package pubsub
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Message outcomes recorded by the subscriber
+const (
+	outcomeAck  = "ack"
+	outcomeNak  = "nak"
+	outcomeTerm = "term"
+)
+
+// Metrics holds the Prometheus collectors recorded by publishers and subscribers.
+// A nil *Metrics records nothing.
+type Metrics struct {
+	published         *prometheus.CounterVec
+	publishErrors     *prometheus.CounterVec
+	publishLatency    *prometheus.HistogramVec
+	ackLatency        *prometheus.HistogramVec
+	handlerDuration   *prometheus.HistogramVec
+	outcomes          *prometheus.CounterVec
+	inFlight          *prometheus.GaugeVec
+	workers           *prometheus.GaugeVec
+	busyWorkers       *prometheus.GaugeVec
+	keyedMessages     *prometheus.CounterVec
+	keySkew           *prometheus.GaugeVec
+	schemaInvalid     *prometheus.CounterVec
+	compression       *prometheus.HistogramVec
+	claimChecks       *prometheus.CounterVec
+	handlerResults    *prometheus.CounterVec
+	rateLimitWait     *prometheus.HistogramVec
+	rateLimitDeferred *prometheus.CounterVec
+	breakerState      *prometheus.GaugeVec
+	breakerChanges    *prometheus.CounterVec
+}
+
+// NewMetrics creates the pubsub collectors and registers them with the registerer
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Subsystem: "publisher",
+			Name:      "messages_published_total",
+			Help:      "Messages successfully published to JetStream.",
+		}, []string{"subject"}),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Subsystem: "publisher",
+			Name:      "publish_errors_total",
+			Help:      "Messages that failed to publish.",
+		}, []string{"subject"}),
+		publishLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pubsub",
+			Subsystem: "publisher",
+			Name:      "publish_duration_seconds",
+			Help:      "Time taken for JetStream to acknowledge a publish.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"subject"}),
+		ackLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "ack_duration_seconds",
+			Help:      "Time taken to acknowledge, nak or terminate a message.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"stream", "outcome"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent processing a message.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"stream", "outcome"}),
+		outcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "messages_total",
+			Help:      "Messages settled by the subscriber, by outcome (ack, nak, term).",
+		}, []string{"stream", "outcome"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "messages_in_flight",
+			Help:      "Messages fetched and waiting in the work queue for a worker.",
+		}, []string{"stream"}),
+		workers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "workers",
+			Help:      "Number of workers in the pool.",
+		}, []string{"stream"}),
+		busyWorkers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "workers_busy",
+			Help:      "Number of workers currently processing a message.",
+		}, []string{"stream"}),
+		keyedMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "keyed_messages_total",
+			Help:      "Messages routed to each worker by their ordering key.",
+		}, []string{"stream", "worker"}),
+		keySkew: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "key_skew_ratio",
+			Help:      "Messages routed to the busiest worker relative to the average, 1 when keys are evenly spread.",
+		}, []string{"stream"}),
+		schemaInvalid: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Name:      "schema_invalid_messages_total",
+			Help:      "Messages failing schema validation, by subject and side (publish, consume).",
+		}, []string{"subject", "side"}),
+		compression: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pubsub",
+			Name:      "compression_ratio",
+			Help:      "Compressed payload size relative to the original, by subject, encoding and side (publish, consume).",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+		}, []string{"subject", "encoding", "side"}),
+		claimChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Name:      "claim_checks_total",
+			Help:      "Payloads stored in or fetched from the object store, by subject and side (publish, consume).",
+		}, []string{"subject", "side"}),
+		handlerResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "handler_results_total",
+			Help:      "Handler calls by subject and result (ok, error, timeout, panic).",
+		}, []string{"subject", "result"}),
+		rateLimitWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "rate_limit_wait_seconds",
+			Help:      "Time messages waited for a rate limiter, by limiter (handler, dispatch).",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"stream", "limiter"}),
+		rateLimitDeferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "rate_limit_deferred_total",
+			Help:      "Messages nak'ed with a delay as their key was over its rate limit.",
+		}, []string{"stream"}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "circuit_breaker_state",
+			Help:      "State of the circuit breaker, 0 closed, 1 half-open, 2 open.",
+		}, []string{"stream"}),
+		breakerChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pubsub",
+			Subsystem: "subscriber",
+			Name:      "circuit_breaker_transitions_total",
+			Help:      "Circuit breaker state changes, by the state entered.",
+		}, []string{"stream", "state"}),
+	}
+
+	reg.MustRegister(
+		m.published,
+		m.publishErrors,
+		m.publishLatency,
+		m.ackLatency,
+		m.handlerDuration,
+		m.outcomes,
+		m.inFlight,
+		m.workers,
+		m.busyWorkers,
+		m.keyedMessages,
+		m.keySkew,
+		m.schemaInvalid,
+		m.compression,
+		m.claimChecks,
+		m.handlerResults,
+		m.rateLimitWait,
+		m.rateLimitDeferred,
+		m.breakerState,
+		m.breakerChanges,
+	)
+
+	return m
+}
+
+func (m *Metrics) observePublish(subject string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.publishErrors.WithLabelValues(subject).Inc()
+		return
+	}
+	m.published.WithLabelValues(subject).Inc()
+	m.publishLatency.WithLabelValues(subject).Observe(d.Seconds())
+}
+
+func (m *Metrics) observeHandler(stream, outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.handlerDuration.WithLabelValues(stream, outcome).Observe(d.Seconds())
+}
+
+func (m *Metrics) observeOutcome(stream, outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.outcomes.WithLabelValues(stream, outcome).Inc()
+	m.ackLatency.WithLabelValues(stream, outcome).Observe(d.Seconds())
+}
+
+func (m *Metrics) addInFlight(stream string, delta int) {
+	if m == nil {
+		return
+	}
+	m.inFlight.WithLabelValues(stream).Add(float64(delta))
+}
+
+func (m *Metrics) setWorkers(stream string, n int) {
+	if m == nil {
+		return
+	}
+	m.workers.WithLabelValues(stream).Set(float64(n))
+}
+
+func (m *Metrics) addBusyWorkers(stream string, delta int) {
+	if m == nil {
+		return
+	}
+	m.busyWorkers.WithLabelValues(stream).Add(float64(delta))
+}
+
+func (m *Metrics) observeKeyed(stream string, worker int, skew float64) {
+	if m == nil {
+		return
+	}
+	m.keyedMessages.WithLabelValues(stream, strconv.Itoa(worker)).Inc()
+	m.keySkew.WithLabelValues(stream).Set(skew)
+}
+
+func (m *Metrics) observeSchemaInvalid(subject, side string) {
+	if m == nil {
+		return
+	}
+	m.schemaInvalid.WithLabelValues(subject, side).Inc()
+}
+
+func (m *Metrics) observeCompression(subject, encoding, side string, compressed, original int) {
+	if m == nil || original == 0 {
+		return
+	}
+	m.compression.WithLabelValues(subject, encoding, side).Observe(float64(compressed) / float64(original))
+}
+
+func (m *Metrics) observeClaimCheck(subject, side string) {
+	if m == nil {
+		return
+	}
+	m.claimChecks.WithLabelValues(subject, side).Inc()
+}
+
+func (m *Metrics) observeHandlerResult(subject, result string) {
+	if m == nil {
+		return
+	}
+	m.handlerResults.WithLabelValues(subject, result).Inc()
+}
+
+func (m *Metrics) observeRateLimitWait(stream, limiter string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.rateLimitWait.WithLabelValues(stream, limiter).Observe(d.Seconds())
+}
+
+func (m *Metrics) observeRateLimitDeferred(stream string) {
+	if m == nil {
+		return
+	}
+	m.rateLimitDeferred.WithLabelValues(stream).Inc()
+}
+
+// breakerStateValues maps circuit breaker states to the gauge value
+var breakerStateValues = map[BreakerState]float64{
+	BreakerClosed:   0,
+	BreakerHalfOpen: 1,
+	BreakerOpen:     2,
+}
+
+func (m *Metrics) setBreakerState(stream string, state BreakerState) {
+	if m == nil {
+		return
+	}
+	m.breakerState.WithLabelValues(stream).Set(breakerStateValues[state])
+}
+
+func (m *Metrics) observeBreakerTransition(stream string, state BreakerState) {
+	if m == nil {
+		return
+	}
+	m.breakerChanges.WithLabelValues(stream, string(state)).Inc()
+	m.setBreakerState(stream, state)
+}
@@ -0,0 +1,186 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/time/rate"
+)
+
+// ErrHandlerTimeout is returned when a handler fails after its timeout expired
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// Handler results recorded by the Instrument middleware
+const (
+	resultOK      = "ok"
+	resultError   = "error"
+	resultTimeout = "timeout"
+	resultPanic   = "panic"
+)
+
+// PanicError is returned by the Recover middleware when a handler panics
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
+
+// Recover turns a panicking handler into one returning a *PanicError, so that
+// the message is nak'ed instead of the process crashing
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					stack := debug.Stack()
+					LoggerFromContext(ctx).Error("Handler panicked", "panic", v, "stack", string(stack))
+					err = &PanicError{Value: v, Stack: stack}
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timeout cancels the handler's context after d. Handlers must honour the
+// context for the timeout to take effect, and an error returned once it has
+// expired is wrapped with ErrHandlerTimeout.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			err := next(ctx, msg)
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%w after %s: %w", ErrHandlerTimeout, d, err)
+			}
+			return err
+		}
+	}
+}
+
+// Logging logs every handled message at the given level with its duration.
+// Failures are logged by the subscriber itself.
+func Logging(level slog.Level) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			if err == nil {
+				LoggerFromContext(ctx).Log(ctx, level, "Handled message", "duration", time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// Instrument records the result of every handler call by subject
+func Instrument(m *Metrics) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			err := next(ctx, msg)
+			m.observeHandlerResult(msg.Subject(), handlerResult(err))
+			return err
+		}
+	}
+}
+
+// handlerResult classifies the error returned by a handler
+func handlerResult(err error) string {
+	var p *PanicError
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.As(err, &p):
+		return resultPanic
+	case errors.Is(err, ErrHandlerTimeout):
+		return resultTimeout
+	default:
+		return resultError
+	}
+}
+
+// Trace runs the handler in a span of its own, a child of the process span
+// started by the subscriber, so that time spent in the handler stands apart
+// from fetching, decoding and settling the message
+func Trace() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			ctx, span := tracing.StartSpan(ctx, msg.Subject()+" handle")
+			defer span.End()
+
+			err := next(ctx, msg)
+			if err != nil {
+				tracing.RecordError(span, err)
+			}
+			return err
+		}
+	}
+}
+
+// RateLimit delays handlers so that messages are handled at most at the
+// limiter's rate across all workers sharing it. Messages waiting when the
+// context is cancelled are nak'ed.
+func RateLimit(limiter *rate.Limiter, m *Metrics) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			start := time.Now()
+			if err := limiter.Wait(ctx); err != nil {
+				return fmt.Errorf("error waiting for rate limiter: %w", err)
+			}
+			if meta, err := msg.Metadata(); err == nil {
+				m.observeRateLimitWait(meta.Stream, "handler", time.Since(start))
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Stack composes the built-in middleware in a defined order, from outermost
+// to innermost: rate limiting, tracing, logging, metrics, timeout, the custom
+// middleware, and panic recovery. Rate limiting comes first so that waiting
+// for the limiter is neither traced nor timed, while recovery wraps the
+// handler directly so that a panic is seen as an error by every other
+// middleware, and e.g. releases an idempotency claim. Fields left zero leave
+// their middleware out, except recovery, which is always included.
+type Stack struct {
+	RateLimiter *rate.Limiter // shared by all workers
+	Tracing     bool
+	Logging     bool
+	LogLevel    slog.Level // level of the log line for every handled message
+	Metrics     *Metrics
+	Timeout     time.Duration
+	Middleware  []Middleware // custom middleware, first outermost
+}
+
+// Build returns the middleware of the stack, to be passed to WithMiddleware
+func (st Stack) Build() []Middleware {
+	var mw []Middleware
+	if st.RateLimiter != nil {
+		mw = append(mw, RateLimit(st.RateLimiter, st.Metrics))
+	}
+	if st.Tracing {
+		mw = append(mw, Trace())
+	}
+	if st.Logging {
+		mw = append(mw, Logging(st.LogLevel))
+	}
+	if st.Metrics != nil {
+		mw = append(mw, Instrument(st.Metrics))
+	}
+	if st.Timeout > 0 {
+		mw = append(mw, Timeout(st.Timeout))
+	}
+	mw = append(mw, st.Middleware...)
+	return append(mw, Recover())
+}
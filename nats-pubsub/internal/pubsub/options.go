@@ -0,0 +1,257 @@
+package pubsub
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/codec"
+	"github.com/fawadmazhar/nats-pubsub/internal/compress"
+	"github.com/fawadmazhar/nats-pubsub/internal/envelope"
+	"github.com/fawadmazhar/nats-pubsub/internal/schema"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// options holds the settings shared by publishers and subscribers
+type options struct {
+	logger     *slog.Logger
+	metrics    *Metrics
+	schemas    *schema.Registry
+	schemaMode schema.Mode
+
+	keyring         *envelope.Keyring
+	encryptSubjects [][]string
+
+	objects        jetstream.ObjectStore
+	claimThreshold int
+}
+
+// Option configures behaviour shared by publishers and subscribers
+type Option func(*options)
+
+func (o Option) applyPublisher(p *Publisher) {
+	o(&p.options)
+}
+
+func (o Option) applySubscriber(s *Subscriber) {
+	o(&s.options)
+}
+
+// PublisherOption configures optional Publisher behaviour
+type PublisherOption interface {
+	applyPublisher(*Publisher)
+}
+
+// SubscriberOption configures optional Subscriber behaviour
+type SubscriberOption interface {
+	applySubscriber(*Subscriber)
+}
+
+type publisherOption func(*Publisher)
+
+func (o publisherOption) applyPublisher(p *Publisher) {
+	o(p)
+}
+
+type subscriberOption func(*Subscriber)
+
+func (o subscriberOption) applySubscriber(s *Subscriber) {
+	o(s)
+}
+
+// WithLogger sets the logger, slog.Default() is used otherwise
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMetrics records client-side Prometheus metrics
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithSchemas validates payloads against the latest schema of their subject
+// when publishing, and against the version in their header when consuming.
+// The mode decides whether invalid payloads are rejected or only logged.
+func WithSchemas(reg *schema.Registry, mode schema.Mode) Option {
+	return func(o *options) {
+		o.schemas = reg
+		o.schemaMode = mode
+	}
+}
+
+// WithEncryption encrypts the payloads of messages published on subjects matching
+// the patterns, which may contain wildcards. Subscribers decrypt every sealed
+// message and reject plaintext ones on those subjects.
+func WithEncryption(kr *envelope.Keyring, subjects ...string) Option {
+	return func(o *options) {
+		o.keyring = kr
+		o.encryptSubjects = nil
+		for _, subject := range subjects {
+			o.encryptSubjects = append(o.encryptSubjects, strings.Split(subject, "."))
+		}
+	}
+}
+
+// WithClaimCheck stores payloads of at least threshold bytes, and messages
+// exceeding the server's max payload, in the object store and publishes a
+// reference instead. A threshold of 0 only offloads the latter. Subscribers
+// fetch referenced payloads before the handler runs.
+func WithClaimCheck(store jetstream.ObjectStore, threshold int) Option {
+	return func(o *options) {
+		o.objects = store
+		o.claimThreshold = threshold
+	}
+}
+
+// WithClaimCheckDelete deletes the object of a claim-checked message once the
+// message is acked. Only use it when a single consumer reads the subject.
+func WithClaimCheckDelete() SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.claimDelete = true
+	})
+}
+
+// WithMiddleware wraps the message handler, the first middleware being
+// outermost. Stack builds the built-in middleware in a defined order. Batch
+// handlers are not wrapped, but are always traced, instrumented and recovered.
+func WithMiddleware(mw ...Middleware) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.middleware = append(s.middleware, mw...)
+	})
+}
+
+// WithCircuitBreaker pauses fetching while handlers fail at the configured
+// rate. It has no effect in ordered mode.
+func WithCircuitBreaker(cfg BreakerConfig) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.breakerCfg = &cfg
+	})
+}
+
+// WithRateLimit throttles the dispatch of messages to the workers, globally
+// and per key. It has no effect in ordered mode.
+func WithRateLimit(cfg RateLimitConfig) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.rateLimit = &cfg
+	})
+}
+
+// WithConnectionState pauses fetching while the NATS connection is down
+func WithConnectionState(conn ConnectionState) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.conn = conn
+	})
+}
+
+// WithHandler sets the function processing each message, LogMessage is used otherwise
+func WithHandler(h Handler) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.handler = h
+	})
+}
+
+// WithGracePeriod sets how long in-flight handlers may run once shutdown starts
+// before their context is cancelled
+func WithGracePeriod(d time.Duration) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.gracePeriod = d
+	})
+}
+
+// WithFetchMaxWait sets how long a single fetch waits for messages to arrive
+func WithFetchMaxWait(d time.Duration) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.fetchMaxWait = d
+	})
+}
+
+// WithFetchMaxBytes bounds the total payload size of a fetch, 0 disables the limit
+func WithFetchMaxBytes(n int) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.fetchMaxBytes = n
+	})
+}
+
+// WithFetchHeartbeat sets the idle heartbeat interval used to detect stalled
+// fetches, 0 disables heartbeats. It must be less than half the fetch max wait.
+func WithFetchHeartbeat(d time.Duration) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.fetchHeartbeat = d
+	})
+}
+
+// WithOrdered switches the subscriber to an ephemeral ordered consumer that
+// processes messages one at a time in stream order
+func WithOrdered() SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.ordered = true
+	})
+}
+
+// WithReplay reprocesses messages from the given start position through a
+// separate ephemeral consumer, stopping at the end bound if one is set
+func WithReplay(r Replay) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.replay = &r
+	})
+}
+
+// WithKeyFunc pins messages to workers by the key returned by fn, keeping
+// messages with the same key in order while different keys run in parallel
+func WithKeyFunc(fn KeyFunc) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.keyFunc = fn
+	})
+}
+
+// WithBatchHandler makes every worker hand batches of up to maxSize messages to
+// h, waiting at most maxWait after the first message for a batch to fill up.
+// The batch handler replaces the per-message handler.
+func WithBatchHandler(h BatchHandler, maxSize int, maxWait time.Duration) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.batchHandler = h
+		s.batchSize = maxSize
+		s.batchWait = maxWait
+	})
+}
+
+// WithPush receives messages from a durable push consumer instead of pulling
+// them, sharing deliveries with the other members of the deliver group. An
+// empty group uses the consumer name. The fetch heartbeat is used as the idle
+// heartbeat required by flow control.
+func WithPush(deliverGroup string) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		s.push = true
+		s.deliverGroup = deliverGroup
+	})
+}
+
+// WithFilterSubjects consumes the given subjects, which may contain wildcards,
+// instead of the single subject passed to NewSubscriber
+func WithFilterSubjects(subjects ...string) SubscriberOption {
+	return subscriberOption(func(s *Subscriber) {
+		if len(subjects) > 0 {
+			s.subjects = subjects
+		}
+	})
+}
+
+// WithCompression compresses payloads of at least threshold bytes, marking them
+// with the encoding header. Payloads that would not shrink are sent as is.
+func WithCompression(c compress.Compressor, threshold int) PublisherOption {
+	return publisherOption(func(p *Publisher) {
+		p.compressor = c
+		p.compressThreshold = threshold
+	})
+}
+
+// WithCodec sets the codec encoding published values, JSON is used otherwise
+func WithCodec(c codec.Codec) PublisherOption {
+	return publisherOption(func(p *Publisher) {
+		p.codec = c
+	})
+}
@@ -0,0 +1,199 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// runOrdered processes messages one at a time in stream order until ctx is
+// cancelled, giving the message being handled the grace period to finish
+func (s *Subscriber) runOrdered(ctx context.Context) error {
+	// Handlers run on a context that survives ctx so the message being handled
+	// can finish during the grace period
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	s.metrics.setWorkers(s.streamName, 1)
+
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errCh <- s.consumeOrdered(ctx, handlerCtx)
+	}()
+
+	s.drain(ctx, done, cancelHandlers)
+	return <-errCh
+}
+
+// orderedConfig returns the ordered consumer settings, starting after the last
+// processed message if there is one, or at the replay start otherwise
+func (s *Subscriber) orderedConfig(ctx context.Context) (jetstream.OrderedConsumerConfig, error) {
+	cfg := jetstream.OrderedConsumerConfig{
+		FilterSubjects: s.subjects,
+	}
+	switch seq := s.lastSeq.Load(); {
+	case seq > 0:
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = seq + 1
+	case s.replay != nil:
+		var err error
+		cfg.DeliverPolicy, cfg.OptStartSeq, cfg.OptStartTime, err = s.deliverPolicy(ctx)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// consumeOrdered reads the stream through an ordered consumer until ctx is
+// cancelled. The client recreates the consumer on sequence gaps or missed
+// heartbeats, and if consumption fails, including when a handler returns an
+// error, a new consumer is created after a backoff, resuming after the last
+// processed message.
+func (s *Subscriber) consumeOrdered(ctx, handlerCtx context.Context) error {
+	backoff := minFetchBackoff
+	for {
+		if err := s.waitConnected(ctx); err != nil {
+			return fmt.Errorf("%w: %w", errConnectionClosed, err)
+		}
+
+		err := s.iterateOrdered(ctx, handlerCtx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errReplayDone) {
+			s.logger.Info("Replay complete", "seq", s.lastSeq.Load())
+			return nil
+		}
+		if errors.Is(err, errConnectionClosed) {
+			return err
+		}
+
+		s.logger.Error("Error consuming messages", "error", err, "seq", s.lastSeq.Load())
+		s.lastErr.set(err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxFetchBackoff)
+	}
+}
+
+// iterateOrdered handles messages from a single ordered consumer until ctx is
+// cancelled or processing fails
+func (s *Subscriber) iterateOrdered(ctx, handlerCtx context.Context) error {
+	cfg, err := s.orderedConfig(ctx)
+	if err != nil {
+		return err
+	}
+	cons, err := s.js.OrderedConsumer(ctx, s.streamName, cfg)
+	if err != nil {
+		return fmt.Errorf("error creating ordered consumer: %w", err)
+	}
+
+	opts := []jetstream.PullMessagesOpt{jetstream.PullExpiry(s.fetchMaxWait)}
+	if s.fetchHeartbeat > 0 {
+		opts = append(opts, jetstream.PullHeartbeat(s.fetchHeartbeat))
+	}
+	it, err := cons.Messages(opts...)
+	if err != nil {
+		return fmt.Errorf("error creating message iterator: %w", err)
+	}
+	defer it.Stop()
+	stop := context.AfterFunc(ctx, it.Stop)
+	defer stop()
+
+	s.bound.Store(true)
+	defer s.bound.Store(false)
+
+	for {
+		if err := s.waitConnected(ctx); err != nil {
+			return fmt.Errorf("%w: %w", errConnectionClosed, err)
+		}
+
+		msg, err := it.Next()
+		switch {
+		case errors.Is(err, jetstream.ErrNoHeartbeat):
+			s.logger.Warn("No heartbeat received from server, retrying", "error", err)
+			continue
+		case errors.Is(err, jetstream.ErrMsgIteratorClosed) && ctx.Err() != nil:
+			return nil
+		case err != nil:
+			return fmt.Errorf("error fetching message: %w", err)
+		}
+
+		// Stop a replay at its end bound
+		last := false
+		if s.replay != nil {
+			meta, err := msg.Metadata()
+			if err != nil {
+				return fmt.Errorf("error reading message metadata: %w", err)
+			}
+			if s.replay.beyond(meta) {
+				return errReplayDone
+			}
+			last = s.replay.last(meta)
+		}
+
+		if err := s.processOrdered(handlerCtx, msg); err != nil {
+			return err
+		}
+		if last {
+			return errReplayDone
+		}
+	}
+}
+
+// processOrdered handles a single message, recording its sequence once handled
+// so that a new consumer resumes after it
+func (s *Subscriber) processOrdered(ctx context.Context, msg jetstream.Msg) error {
+	s.received.Add(1)
+	start := time.Now()
+	logger := s.logger.With(messageAttrs(msg)...)
+
+	// Continue the trace started by the publisher
+	ctx, span := tracing.StartConsumerSpan(ctx, msg.Subject(), msg.Headers(), s.spanAttributes(msg)...)
+	defer span.End()
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		return fmt.Errorf("error reading message metadata: %w", err)
+	}
+
+	// Skip invalid payloads rather than retrying them forever, but retry
+	// failures that may be transient
+	prepared, err := s.prepare(ctx, logger, msg)
+	if err != nil && rejectOutcome(err) == outcomeNak {
+		tracing.RecordError(span, err)
+		s.nacked.Add(1)
+		return fmt.Errorf("error preparing message %d: %w", meta.Sequence.Stream, err)
+	}
+	if err != nil {
+		logger.Error("Skipping message", "error", err)
+		tracing.RecordError(span, err)
+		s.lastErr.set(err)
+		s.nacked.Add(1)
+		s.lastSeq.Store(meta.Sequence.Stream)
+		return nil
+	}
+
+	if err := s.handler(withLogger(ctx, logger), prepared); err != nil {
+		tracing.RecordError(span, err)
+		s.metrics.observeHandler(s.streamName, outcomeNak, time.Since(start))
+		s.nacked.Add(1)
+		return fmt.Errorf("error handling message %d: %w", meta.Sequence.Stream, err)
+	}
+
+	s.metrics.observeHandler(s.streamName, outcomeAck, time.Since(start))
+	s.acked.Add(1)
+	s.lastSeq.Store(meta.Sequence.Stream)
+	return nil
+}
@@ -2,18 +2,32 @@ package pubsub
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
+	"github.com/fawadmazhar/nats-pubsub/internal/codec"
+	"github.com/fawadmazhar/nats-pubsub/internal/compress"
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 // Publisher handles publishing messages to NATS JetStream
 type Publisher struct {
-	js          nats.JetStreamContext
+	js          jetstream.JetStream
 	subjectName string
+	codec       codec.Codec
+	options
+
+	compressor        compress.Compressor
+	compressThreshold int
+
+	published atomic.Uint64
+	failed    atomic.Uint64
+	lastErr   lastError
 }
 
 // Message represents a sample message structure
@@ -24,10 +38,30 @@ type Message struct {
 }
 
 // NewPublisher creates a new publisher instance
-func NewPublisher(js nats.JetStreamContext, subjectName string) *Publisher {
-	return &Publisher{
+func NewPublisher(js jetstream.JetStream, subjectName string, opts ...PublisherOption) *Publisher {
+	p := &Publisher{
 		js:          js,
 		subjectName: subjectName,
+		codec:       codec.JSON,
+	}
+	for _, opt := range opts {
+		opt.applyPublisher(p)
+	}
+	if p.logger == nil {
+		p.logger = slog.Default()
+	}
+	p.logger = p.logger.With("subject", subjectName)
+	return p
+}
+
+// Stats returns a snapshot of the publisher's counters
+func (p *Publisher) Stats() PublisherStats {
+	lastErr, lastErrAt := p.lastErr.get()
+	return PublisherStats{
+		Published:   p.published.Load(),
+		Failed:      p.failed.Load(),
+		LastError:   lastErr,
+		LastErrorAt: lastErrAt,
 	}
 }
 
@@ -48,23 +82,115 @@ func (p *Publisher) Run(ctx context.Context, interval time.Duration) error {
 				Timestamp: time.Now(),
 			}
 
-			data, err := json.Marshal(msg)
-			if err != nil {
-				log.Printf("Error marshaling message: %v", err)
-				continue
-			}
-
-			// Publish message with message ID
-			_, err = p.js.Publish(p.subjectName, data, nats.MsgId(msg.ID))
-			if err != nil {
-				log.Printf("Error publishing message: %v", err)
+			if err := p.Publish(ctx, msg); err != nil {
+				p.logger.Error("Error publishing message", "msg_id", msg.ID, "error", err)
 				continue
 			}
 
-			log.Printf("Published message: %s", msg.ID)
+			p.logger.Info("Published message", "msg_id", msg.ID)
 
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
+
+// Publish marshals and publishes a message, using its ID for deduplication
+func (p *Publisher) Publish(ctx context.Context, message Message) error {
+	return p.PublishValue(ctx, message.ID, message)
+}
+
+// PublishValue encodes v with the publisher's codec, stamping its content type,
+// and publishes it. A non-empty id is used for deduplication.
+func (p *Publisher) PublishValue(ctx context.Context, id string, v any) error {
+	data, err := p.codec.Marshal(v)
+	if err != nil {
+		p.failed.Add(1)
+		p.lastErr.set(err)
+		return fmt.Errorf("error marshaling message: %w", err)
+	}
+
+	msg := nats.NewMsg(p.subjectName)
+	msg.Data = data
+	msg.Header.Set(codec.ContentTypeHeader, p.codec.ContentType())
+	if id != "" {
+		msg.Header.Set(nats.MsgIdHdr, id)
+	}
+
+	return p.PublishMsg(ctx, msg)
+}
+
+// PublishMsg publishes a prepared message, propagating the trace context in its headers
+func (p *Publisher) PublishMsg(ctx context.Context, msg *nats.Msg) error {
+	ctx, span := tracing.StartProducerSpan(ctx, msg)
+	defer span.End()
+
+	// Validate the payload before it is compressed, compress it before it is
+	// encrypted and store what is left if it is too large
+	var claimed string
+	err := p.checkSchema(msg)
+	if err == nil {
+		err = p.compress(msg)
+	}
+	if err == nil {
+		err = p.encrypt(msg)
+	}
+	// Preparing the message again would fail the same way, storing it may not
+	if err != nil {
+		err = permanent(err)
+	} else {
+		claimed, err = p.claimCheck(ctx, msg)
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		p.metrics.observePublish(msg.Subject, 0, err)
+		p.failed.Add(1)
+		p.lastErr.set(err)
+		return err
+	}
+
+	start := time.Now()
+	_, err = p.js.PublishMsg(ctx, msg)
+	p.metrics.observePublish(msg.Subject, time.Since(start), err)
+	if errors.Is(err, nats.ErrMaxPayload) {
+		err = permanent(err)
+	}
+	if err != nil {
+		if claimed != "" {
+			p.discardClaim(claimed)
+		}
+		tracing.RecordError(span, err)
+		p.failed.Add(1)
+		p.lastErr.set(err)
+		return err
+	}
+
+	p.published.Add(1)
+	return nil
+}
+
+// permanentError marks a failure to publish a message that publishing the same
+// message again cannot fix
+type permanentError struct {
+	err error
+}
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent reports whether PublishMsg failed in a way retrying cannot fix: the
+// payload does not match its schema, cannot be compressed or encrypted, or
+// exceeds the server's max payload without a claim check
+func Permanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// pushConsumerName returns the name of the durable push consumer, distinct from
+// the pull consumer as a consumer cannot switch between the two
+func (s *Subscriber) pushConsumerName() string {
+	return fmt.Sprintf("%s-push-consumer", s.streamName)
+}
+
+// pushConsumer creates the durable push consumer, or updates it if it already
+// exists. Every subscriber of the deliver group shares its deliver subject.
+func (s *Subscriber) pushConsumer(ctx context.Context) (jetstream.PushConsumer, error) {
+	name := s.pushConsumerName()
+	group := s.deliverGroup
+	if group == "" {
+		group = name
+	}
+
+	cfg := jetstream.ConsumerConfig{
+		Durable:        name,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		DeliverSubject: fmt.Sprintf("_DELIVER.%s.%s", s.streamName, name),
+		DeliverGroup:   group,
+		FlowControl:    true,
+		IdleHeartbeat:  s.fetchHeartbeat,
+	}
+	s.setFilter(&cfg)
+
+	cons, err := s.js.CreateOrUpdatePushConsumer(ctx, s.streamName, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating push consumer: %w", err)
+	}
+	return cons, nil
+}
+
+// consumePush receives messages pushed by the server and dispatches them to the
+// workers until ctx is cancelled. Delivery blocks while every worker slot is
+// taken, letting flow control slow the server down. On cancellation the
+// subscription is drained and the messages still being delivered are nak'ed.
+func (s *Subscriber) consumePush(ctx context.Context, d *dispatcher, slots chan struct{}) error {
+	if s.fetchHeartbeat <= 0 {
+		return errors.New("push mode requires an idle heartbeat for flow control")
+	}
+
+	cons, err := s.pushConsumer(ctx)
+	if err != nil {
+		return err
+	}
+
+	cc, err := cons.Consume(
+		func(msg jetstream.Msg) {
+			s.deliver(ctx, d, slots, msg)
+		},
+		jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+			s.logger.Warn("Error consuming pushed messages", "error", err)
+			s.lastErr.set(err)
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("error consuming messages: %w", err)
+	}
+
+	s.bound.Store(true)
+	defer s.bound.Store(false)
+
+	select {
+	case <-ctx.Done():
+		cc.Drain()
+		<-cc.Closed()
+		return nil
+	case <-cc.Closed():
+		return errors.New("push subscription closed")
+	}
+}
+
+// deliver waits for an idle worker, the global rate limit and the circuit
+// breaker to let the message through, then dispatches it to the worker, or
+// naks it if shutdown starts first. Waiting blocks delivery, letting flow
+// control slow the server down.
+func (s *Subscriber) deliver(ctx context.Context, d *dispatcher, slots chan struct{}, msg jetstream.Msg) {
+	select {
+	case <-ctx.Done():
+		s.settle(s.logger.With(messageAttrs(msg)...), msg, outcomeNak)
+	case <-slots:
+		if s.deferByKey(msg) {
+			slots <- struct{}{}
+			return
+		}
+		if err := s.throttle(ctx); err != nil {
+			s.settle(s.logger.With(messageAttrs(msg)...), msg, outcomeNak)
+			slots <- struct{}{}
+			return
+		}
+		if !s.admitPushed(ctx, msg) {
+			slots <- struct{}{}
+			return
+		}
+		s.dispatch(d, msg)
+	}
+}
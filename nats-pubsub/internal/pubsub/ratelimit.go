@@ -0,0 +1,178 @@
+package pubsub
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/time/rate"
+)
+
+// pruneInterval is how often idle per-key limiters are dropped
+const pruneInterval = time.Minute
+
+// RateLimitConfig configures the token buckets throttling the dispatch of
+// messages to the workers. Zero rates disable the matching limit.
+type RateLimitConfig struct {
+	Rate        float64 // messages dispatched per second across the subscriber
+	Burst       int     // messages dispatched at once above the rate, at least 1
+	PerKeyRate  float64 // messages dispatched per second for each key
+	PerKeyBurst int     // messages of a key dispatched at once above its rate, at least 1
+	Key         KeyFunc // key of the per-key limits, the subject when nil
+}
+
+// dispatchLimiter throttles the dispatch of messages, globally and per key.
+// A nil *dispatchLimiter lets every message through.
+type dispatchLimiter struct {
+	global      *rate.Limiter
+	perKeyRate  rate.Limit
+	perKeyBurst int
+	key         KeyFunc
+
+	mu       sync.Mutex
+	keys     map[string]*rate.Limiter
+	reserved map[uint64]time.Time // token times reserved by deferred messages, by stream sequence
+	pruned   time.Time
+}
+
+func newDispatchLimiter(cfg RateLimitConfig) *dispatchLimiter {
+	l := &dispatchLimiter{
+		perKeyRate:  rate.Limit(cfg.PerKeyRate),
+		perKeyBurst: max(cfg.PerKeyBurst, 1),
+		key:         cfg.Key,
+		keys:        make(map[string]*rate.Limiter),
+		reserved:    make(map[uint64]time.Time),
+		pruned:      time.Now(),
+	}
+	if cfg.Rate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(cfg.Rate), max(cfg.Burst, 1))
+	}
+	if l.key == nil {
+		l.key = Subject
+	}
+	return l
+}
+
+// fetchSize returns how many messages to buffer out of capacity. With a global
+// limit at most a second's worth of messages is buffered, so that messages
+// wait for their turn in the stream rather than in memory, where their ack
+// wait would run out.
+func (l *dispatchLimiter) fetchSize(capacity int) int {
+	if l == nil || l.global == nil {
+		return capacity
+	}
+	perSecond := int(math.Ceil(float64(l.global.Limit())))
+	return min(capacity, max(1, l.global.Burst(), perSecond))
+}
+
+// keyed reports whether messages are limited per key
+func (l *dispatchLimiter) keyed() bool {
+	return l != nil && l.perKeyRate > 0
+}
+
+// wait blocks until the global limit lets a message be dispatched
+func (l *dispatchLimiter) wait(ctx context.Context) error {
+	if l == nil || l.global == nil {
+		return nil
+	}
+	return l.global.Wait(ctx)
+}
+
+// keyDelay takes a token from the limit of the message's key, returning zero
+// if the message may be dispatched now and otherwise how long until it may be.
+// A message that has to wait keeps the token reserved for it and uses it when
+// redelivered, so the deferred messages of a key are spread out over its rate
+// rather than all coming back at once. Messages whose key cannot be extracted
+// share the empty key.
+func (l *dispatchLimiter) keyDelay(msg jetstream.Msg) time.Duration {
+	if !l.keyed() {
+		return 0
+	}
+	key, _ := l.key(msg)
+	var seq uint64
+	if meta, err := msg.Metadata(); err == nil {
+		seq = meta.Sequence.Stream
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.pruned) >= pruneInterval {
+		l.prune(now)
+	}
+	if at, ok := l.reserved[seq]; ok {
+		if now.Before(at) {
+			return at.Sub(now)
+		}
+		delete(l.reserved, seq)
+		return 0
+	}
+
+	lim, ok := l.keys[key]
+	if !ok {
+		lim = rate.NewLimiter(l.perKeyRate, l.perKeyBurst)
+		l.keys[key] = lim
+	}
+
+	r := lim.ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	switch {
+	case delay <= 0:
+	case seq != 0:
+		l.reserved[seq] = now.Add(delay)
+	default:
+		// Without a sequence the redelivery cannot claim the token
+		r.CancelAt(now)
+	}
+	return delay
+}
+
+// prune drops the limiters of keys that have refilled their bucket, as they
+// behave like new ones, and the reservations of deferred messages that were
+// not redelivered here. The lock must be held.
+func (l *dispatchLimiter) prune(now time.Time) {
+	for key, lim := range l.keys {
+		if lim.TokensAt(now) >= float64(l.perKeyBurst) {
+			delete(l.keys, key)
+		}
+	}
+	for seq, at := range l.reserved {
+		if now.Sub(at) >= pruneInterval {
+			delete(l.reserved, seq)
+		}
+	}
+	l.pruned = now
+}
+
+// throttle waits for the global rate limit, recording how long it took
+func (s *Subscriber) throttle(ctx context.Context) error {
+	if s.limiter == nil {
+		return nil
+	}
+	start := time.Now()
+	if err := s.limiter.wait(ctx); err != nil {
+		return err
+	}
+	s.metrics.observeRateLimitWait(s.streamName, "dispatch", time.Since(start))
+	return nil
+}
+
+// deferByKey naks a message whose key is over its rate limit, with a delay
+// until the token reserved for it is due. It reports whether the message was
+// deferred.
+// It runs before the global limit is waited for, so that a deferred message
+// does not use up a global token. The redelivered message counts as another
+// delivery attempt and may overtake later messages of its key, which is why
+// per-key limits cannot be combined with ordering keys.
+func (s *Subscriber) deferByKey(msg jetstream.Msg) bool {
+	delay := s.limiter.keyDelay(msg)
+	if delay <= 0 {
+		return false
+	}
+	s.metrics.observeRateLimitDeferred(s.streamName)
+	s.settleAfter(s.logger.With(messageAttrs(msg)...), msg, outcomeNak, delay)
+	return true
+}
@@ -0,0 +1,59 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// seqMsg is a test message with a stream sequence
+type seqMsg struct {
+	*testMsg
+	seq uint64
+}
+
+func (m *seqMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{Sequence: jetstream.SequencePair{Stream: m.seq}}, nil
+}
+
+func TestKeyDelayStaggersDeferredMessages(t *testing.T) {
+	l := newDispatchLimiter(RateLimitConfig{PerKeyRate: 1, PerKeyBurst: 1})
+	msg := func(seq uint64) jetstream.Msg {
+		return &seqMsg{testMsg: newTestMsg("ORDERS.received", ""), seq: seq}
+	}
+
+	if d := l.keyDelay(msg(1)); d != 0 {
+		t.Fatalf("first message delay = %s, want 0", d)
+	}
+	// Each deferred message keeps its token, so the next one waits a second longer
+	var prev time.Duration
+	for seq := uint64(2); seq <= 4; seq++ {
+		d := l.keyDelay(msg(seq))
+		if d < prev+900*time.Millisecond {
+			t.Errorf("message %d delay = %s, want about a second after %s", seq, d, prev)
+		}
+		prev = d
+	}
+
+	// Redelivered early, a message waits for the rest of its delay
+	if d := l.keyDelay(msg(2)); d <= 0 || d > time.Second {
+		t.Errorf("early redelivery delay = %s, want the rest of a second", d)
+	}
+	// Once its token is due it is dispatched without taking another one
+	l.mu.Lock()
+	l.reserved[2] = time.Now().Add(-time.Millisecond)
+	l.mu.Unlock()
+	if d := l.keyDelay(msg(2)); d != 0 {
+		t.Errorf("redelivery delay = %s, want 0", d)
+	}
+	if d := l.keyDelay(msg(5)); d < prev+900*time.Millisecond {
+		t.Errorf("next message delay = %s, want about a second after %s", d, prev)
+	}
+
+	// Other keys are not held up
+	other := &seqMsg{testMsg: newTestMsg("ORDERS.shipped", ""), seq: 6}
+	if d := l.keyDelay(other); d != 0 {
+		t.Errorf("other key delay = %s, want 0", d)
+	}
+}
@@ -0,0 +1,177 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+// replayInactiveThreshold is how long the server keeps an idle replay consumer
+const replayInactiveThreshold = 5 * time.Minute
+
+// errReplayDone stops consumption once a replay reaches its end bound
+var errReplayDone = errors.New("replay reached its end bound")
+
+// Replay selects where a replay starts and, optionally, where it stops. At most
+// one start option may be set. Replays read through a separate ephemeral
+// consumer, leaving the position of the durable consumer untouched.
+type Replay struct {
+	StartSeq       uint64    // first stream sequence to deliver
+	StartTime      time.Time // deliver messages stored at or after this time
+	LastN          uint64    // deliver the last N messages on the subscribed subjects
+	LastPerSubject bool      // deliver the last message of every subject
+
+	EndSeq  uint64    // stop after this stream sequence, 0 for no bound
+	EndTime time.Time // stop after messages stored at this time, zero for no bound
+}
+
+// validate returns an error if more than one start option is set
+func (r *Replay) validate() error {
+	n := 0
+	if r.StartSeq > 0 {
+		n++
+	}
+	if !r.StartTime.IsZero() {
+		n++
+	}
+	if r.LastN > 0 {
+		n++
+	}
+	if r.LastPerSubject {
+		n++
+	}
+	if n > 1 {
+		return errors.New("only one replay start option may be set")
+	}
+	return nil
+}
+
+// bounded reports whether the replay has an end bound
+func (r *Replay) bounded() bool {
+	return r.EndSeq > 0 || !r.EndTime.IsZero()
+}
+
+// beyond reports whether a message lies past the end bound
+func (r *Replay) beyond(meta *jetstream.MsgMetadata) bool {
+	if r.EndSeq > 0 && meta.Sequence.Stream > r.EndSeq {
+		return true
+	}
+	return !r.EndTime.IsZero() && meta.Timestamp.After(r.EndTime)
+}
+
+// last reports whether a message is the final one of a bounded replay, either
+// because it reaches the end sequence or because the replay has caught up
+func (r *Replay) last(meta *jetstream.MsgMetadata) bool {
+	if !r.bounded() {
+		return false
+	}
+	return meta.Sequence.Stream == r.EndSeq || meta.NumPending == 0
+}
+
+// deliverPolicy returns the deliver policy and start position of the replay.
+// The last N messages are located from the current state of the stream.
+func (s *Subscriber) deliverPolicy(ctx context.Context) (jetstream.DeliverPolicy, uint64, *time.Time, error) {
+	r := s.replay
+	switch {
+	case r.StartSeq > 0:
+		return jetstream.DeliverByStartSequencePolicy, r.StartSeq, nil, nil
+	case !r.StartTime.IsZero():
+		return jetstream.DeliverByStartTimePolicy, 0, &r.StartTime, nil
+	case r.LastPerSubject:
+		return jetstream.DeliverLastPerSubjectPolicy, 0, nil, nil
+	case r.LastN > 0:
+		seq, err := s.lastNStart(ctx, r.LastN)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if seq == 0 {
+			return jetstream.DeliverAllPolicy, 0, nil, nil
+		}
+		return jetstream.DeliverByStartSequencePolicy, seq, nil, nil
+	default:
+		return jetstream.DeliverAllPolicy, 0, nil, nil
+	}
+}
+
+// lastNStart returns the stream sequence of the Nth last message on the
+// subscribed subjects, or 0 if there are no more than n of them. Messages on
+// other subjects and deleted messages leave gaps in the sequence, so it is
+// found by binary search on the messages pending from a start sequence.
+func (s *Subscriber) lastNStart(ctx context.Context, n uint64) (uint64, error) {
+	stream, err := s.js.Stream(ctx, s.streamName)
+	if err != nil {
+		return 0, fmt.Errorf("error checking stream info: %w", err)
+	}
+	state := stream.CachedInfo().State
+
+	lo, hi := max(state.FirstSeq, 1), state.LastSeq
+	total, err := s.pendingFrom(ctx, stream, lo)
+	if err != nil || total <= n {
+		return 0, err
+	}
+
+	// Find the highest sequence from which at least n messages are pending
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		pending, err := s.pendingFrom(ctx, stream, mid)
+		if err != nil {
+			return 0, err
+		}
+		if pending >= n {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, nil
+}
+
+// pendingFrom counts the messages on the subscribed subjects stored at or
+// after seq, through a short-lived consumer that never pulls
+func (s *Subscriber) pendingFrom(ctx context.Context, stream jetstream.Stream, seq uint64) (uint64, error) {
+	cfg := jetstream.ConsumerConfig{
+		Name:              fmt.Sprintf("%s-count-%s", s.streamName, nuid.Next()),
+		AckPolicy:         jetstream.AckNonePolicy,
+		DeliverPolicy:     jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:       seq,
+		InactiveThreshold: replayInactiveThreshold,
+	}
+	s.setFilter(&cfg)
+
+	cons, err := stream.CreateConsumer(ctx, cfg)
+	if err != nil {
+		return 0, fmt.Errorf("error counting messages: %w", err)
+	}
+	defer func() {
+		_ = stream.DeleteConsumer(context.WithoutCancel(ctx), cfg.Name)
+	}()
+	return cons.CachedInfo().NumPending, nil
+}
+
+// replayConfig returns the configuration of the ephemeral replay consumer. It
+// is built once so that binding again after a failure continues the replay
+// from the consumer's position rather than starting over.
+func (s *Subscriber) replayConfig(ctx context.Context) (jetstream.ConsumerConfig, error) {
+	if s.replayCfg != nil {
+		return *s.replayCfg, nil
+	}
+
+	policy, seq, start, err := s.deliverPolicy(ctx)
+	if err != nil {
+		return jetstream.ConsumerConfig{}, err
+	}
+	s.replayCfg = &jetstream.ConsumerConfig{
+		Name:              fmt.Sprintf("%s-replay-%s", s.streamName, nuid.Next()),
+		AckPolicy:         jetstream.AckExplicitPolicy,
+		DeliverPolicy:     policy,
+		OptStartSeq:       seq,
+		OptStartTime:      start,
+		InactiveThreshold: replayInactiveThreshold,
+	}
+	s.setFilter(s.replayCfg)
+	return *s.replayCfg, nil
+}
@@ -0,0 +1,72 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrNoRoute is returned by Router.Dispatch for a message matching no pattern
+// when no fallback handler is set
+var ErrNoRoute = errors.New("no handler for subject")
+
+type route struct {
+	pattern []string
+	handler Handler
+}
+
+// Router dispatches messages to handlers registered per subject pattern.
+// Patterns use NATS wildcards: * matches a single token and > matches one or
+// more trailing tokens. Patterns are tried in the order they were registered.
+type Router struct {
+	routes   []route
+	fallback Handler
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers the handler for subjects matching the pattern
+func (r *Router) Handle(pattern string, h Handler) {
+	r.routes = append(r.routes, route{pattern: strings.Split(pattern, "."), handler: h})
+}
+
+// Fallback sets the handler for subjects matching no pattern
+func (r *Router) Fallback(h Handler) {
+	r.fallback = h
+}
+
+// Dispatch passes the message to the handler of the first matching pattern and
+// can be used as the Handler of a Subscriber
+func (r *Router) Dispatch(ctx context.Context, msg jetstream.Msg) error {
+	subject := strings.Split(msg.Subject(), ".")
+	for _, rt := range r.routes {
+		if matchSubject(rt.pattern, subject) {
+			return rt.handler(ctx, msg)
+		}
+	}
+	if r.fallback != nil {
+		return r.fallback(ctx, msg)
+	}
+	return fmt.Errorf("%w %s", ErrNoRoute, msg.Subject())
+}
+
+// matchSubject reports whether the subject tokens match the pattern tokens
+func matchSubject(pattern, subject []string) bool {
+	for i, p := range pattern {
+		switch {
+		case p == ">":
+			return len(subject) > i
+		case i >= len(subject):
+			return false
+		case p != "*" && p != subject[i]:
+			return false
+		}
+	}
+	return len(pattern) == len(subject)
+}
@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		want    bool
+	}{
+		{"ORDERS.received", "ORDERS.received", true},
+		{"ORDERS.received", "ORDERS.shipped", false},
+		{"ORDERS.received", "ORDERS.received.eu", false},
+		{"ORDERS.*", "ORDERS.received", true},
+		{"ORDERS.*", "ORDERS", false},
+		{"ORDERS.*", "ORDERS.received.eu", false},
+		{"ORDERS.*.eu", "ORDERS.received.eu", true},
+		{"ORDERS.*.eu", "ORDERS.received.us", false},
+		{"ORDERS.>", "ORDERS.received", true},
+		{"ORDERS.>", "ORDERS.received.eu", true},
+		{"ORDERS.>", "ORDERS", false},
+		{"*.>", "ORDERS.received", true},
+		{">", "ORDERS", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.subject, func(t *testing.T) {
+			got := matchSubject(strings.Split(tt.pattern, "."), strings.Split(tt.subject, "."))
+			if got != tt.want {
+				t.Errorf("matchSubject(%s, %s) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	var called string
+	handler := func(name string) Handler {
+		return func(ctx context.Context, msg jetstream.Msg) error {
+			called = name
+			return nil
+		}
+	}
+
+	r := NewRouter()
+	r.Handle("ORDERS.received", handler("received"))
+	r.Handle("ORDERS.*", handler("any order"))
+	r.Handle("ORDERS.>", handler("order tree"))
+
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"ORDERS.received", "received"},
+		{"ORDERS.shipped", "any order"},
+		{"ORDERS.shipped.eu", "order tree"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			called = ""
+			if err := r.Dispatch(context.Background(), newTestMsg(tt.subject, "")); err != nil {
+				t.Fatalf("Dispatch error = %v", err)
+			}
+			if called != tt.want {
+				t.Errorf("handler = %q, want %q", called, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterNoRoute(t *testing.T) {
+	r := NewRouter()
+	r.Handle("ORDERS.*", func(ctx context.Context, msg jetstream.Msg) error { return nil })
+
+	msg := newTestMsg("PAYMENTS.received", "")
+	if err := r.Dispatch(context.Background(), msg); !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("Dispatch error = %v, want %v", err, ErrNoRoute)
+	}
+
+	fallback := false
+	r.Fallback(func(ctx context.Context, msg jetstream.Msg) error {
+		fallback = true
+		return nil
+	})
+	if err := r.Dispatch(context.Background(), msg); err != nil || !fallback {
+		t.Errorf("Dispatch = %v with fallback called %v, want the fallback to handle it", err, fallback)
+	}
+}
@@ -0,0 +1,116 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/codec"
+	"github.com/fawadmazhar/nats-pubsub/internal/schema"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrInvalidPayload is returned for payloads rejected by schema validation
+var ErrInvalidPayload = errors.New("payload does not match schema")
+
+// unknownSchemaDelay is the redelivery delay of a message stamped with a schema
+// version that is not registered yet
+const unknownSchemaDelay = 30 * time.Second
+
+// checkSchema validates an outgoing message against the latest schema of its
+// subject and stamps the schema version. Subjects without a schema pass.
+func (p *Publisher) checkSchema(msg *nats.Msg) error {
+	if p.schemas == nil {
+		return nil
+	}
+	sch, ok := p.schemas.Latest(msg.Subject)
+	if !ok {
+		return nil
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(schema.VersionHeader, strconv.Itoa(sch.Version))
+
+	err := sch.Validate(msg.Header.Get(codec.ContentTypeHeader), msg.Data)
+	if err == nil {
+		return nil
+	}
+	p.metrics.observeSchemaInvalid(msg.Subject, "publish")
+	if p.schemaMode == schema.ModeWarn {
+		p.logger.Warn("Message does not match schema", "schema_version", sch.Version, "error", err)
+		return nil
+	}
+	return fmt.Errorf("%w version %d: %w", ErrInvalidPayload, sch.Version, err)
+}
+
+// checkSchema validates a consumed message against the schema version in its
+// header, or the latest version of its subject if the header is missing. It
+// returns an error only for messages to reject. A version missing from the
+// cache is read from the registry bucket, and if it is not there either, or
+// the bucket cannot be read, the message is retried after a delay, as its
+// schema may be registered by a publisher that is being rolled out.
+func (s *Subscriber) checkSchema(ctx context.Context, logger *slog.Logger, msg jetstream.Msg) error {
+	if s.schemas == nil {
+		return nil
+	}
+
+	sch, err := s.schemaOf(ctx, msg)
+	var r *retryableError
+	if errors.As(err, &r) {
+		if s.schemaMode == schema.ModeWarn {
+			logger.Warn("Message schema unavailable", "error", err)
+			return nil
+		}
+		return err
+	}
+	if err == nil {
+		if sch == nil {
+			return nil
+		}
+		if err = sch.Validate(msg.Headers().Get(codec.ContentTypeHeader), msg.Data()); err == nil {
+			return nil
+		}
+	}
+
+	s.metrics.observeSchemaInvalid(msg.Subject(), "consume")
+	if s.schemaMode == schema.ModeWarn {
+		logger.Warn("Message does not match schema", "error", err)
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+}
+
+// schemaOf returns the schema to validate a consumed message against, nil if
+// its subject has none. Errors are retryable unless the version header is
+// malformed or the stored schema does not compile.
+func (s *Subscriber) schemaOf(ctx context.Context, msg jetstream.Msg) (*schema.Schema, error) {
+	v := msg.Headers().Get(schema.VersionHeader)
+	if v == "" {
+		sch, _ := s.schemas.Latest(msg.Subject())
+		return sch, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema version %s", v)
+	}
+	if sch, ok := s.schemas.Version(msg.Subject(), version); ok {
+		return sch, nil
+	}
+
+	sch, err := s.schemas.Fetch(ctx, msg.Subject(), version)
+	switch {
+	case errors.Is(err, schema.ErrInvalidSchema):
+		return nil, err
+	case err == nil && sch == nil:
+		err = fmt.Errorf("unknown schema version %d", version)
+	}
+	if err != nil {
+		return nil, retryable(RetryAfter(unknownSchemaDelay, err))
+	}
+	return sch, nil
+}
@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/codec"
+	"github.com/fawadmazhar/nats-pubsub/internal/schema"
+)
+
+const orderSchema = `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`
+
+// newSchemaSubscriber returns a subscriber validating against a registry
+// backed by kv, whose cache starts out empty
+func newSchemaSubscriber(t *testing.T, kv *fakeKV, mode schema.Mode) *Subscriber {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	reg, err := schema.NewRegistry(ctx, &fakeJetStream{kv: kv}, schema.DefaultBucket, schema.CompatNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Subscriber{options: options{schemas: reg, schemaMode: mode}}
+}
+
+func schemaMsg(version, data string) *testMsg {
+	msg := newTestMsg("ORDERS.received", data)
+	msg.headers.Set(codec.ContentTypeHeader, codec.JSON.ContentType())
+	msg.headers.Set(schema.VersionHeader, version)
+	return msg
+}
+
+func TestCheckSchemaFetchesVersion(t *testing.T) {
+	kv := newFakeKV()
+	kv.set("ORDERS.received.1", []byte(orderSchema))
+	s := newSchemaSubscriber(t, kv, schema.ModeReject)
+
+	if err := s.checkSchema(context.Background(), slog.Default(), schemaMsg("1", `{"id":"o-1"}`)); err != nil {
+		t.Fatalf("checkSchema error = %v", err)
+	}
+	err := s.checkSchema(context.Background(), slog.Default(), schemaMsg("1", `{"total":3}`))
+	if !errors.Is(err, ErrInvalidPayload) || rejectOutcome(err) != outcomeTerm {
+		t.Errorf("checkSchema error = %v, want %v terminating the message", err, ErrInvalidPayload)
+	}
+}
+
+func TestCheckSchemaRetriesUnknownVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		getErr error
+	}{
+		{"version not registered", nil},
+		{"bucket unavailable", errors.New("timeout")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newFakeKV()
+			s := newSchemaSubscriber(t, kv, schema.ModeReject)
+			kv.getErr = tt.getErr
+
+			err := s.checkSchema(context.Background(), slog.Default(), schemaMsg("2", `{"id":"o-1"}`))
+			if err == nil {
+				t.Fatal("checkSchema succeeded, want an error")
+			}
+			if got := rejectOutcome(err); got != outcomeNak {
+				t.Errorf("outcome = %s, want %s", got, outcomeNak)
+			}
+			if got := retryDelay(err); got != unknownSchemaDelay {
+				t.Errorf("delay = %s, want %s", got, unknownSchemaDelay)
+			}
+		})
+	}
+}
+
+func TestCheckSchemaTerminatesInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		version string
+	}{
+		{"schema does not compile", `{"type": 3}`, "1"},
+		{"malformed version", orderSchema, "one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newFakeKV()
+			kv.set("ORDERS.received.1", []byte(tt.stored))
+			s := newSchemaSubscriber(t, kv, schema.ModeReject)
+
+			err := s.checkSchema(context.Background(), slog.Default(), schemaMsg(tt.version, `{"id":"o-1"}`))
+			if err == nil {
+				t.Fatal("checkSchema succeeded, want an error")
+			}
+			if got := rejectOutcome(err); got != outcomeTerm {
+				t.Errorf("outcome = %s, want %s", got, outcomeTerm)
+			}
+		})
+	}
+}
+
+func TestCheckSchemaWarnMode(t *testing.T) {
+	s := newSchemaSubscriber(t, newFakeKV(), schema.ModeWarn)
+	if err := s.checkSchema(context.Background(), slog.Default(), schemaMsg("2", `{"id":"o-1"}`)); err != nil {
+		t.Errorf("checkSchema error = %v, want the message passed on", err)
+	}
+}
@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// PublisherStats is a snapshot of the publisher's counters
+type PublisherStats struct {
+	Published   uint64     `json:"published"`
+	Failed      uint64     `json:"failed"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// SubscriberStats is a snapshot of the subscriber's counters. In ordered mode,
+// where messages are not acknowledged, Acked counts processed messages, Nacked
+// failed attempts and LastSeq is the stream sequence processing resumes after.
+type SubscriberStats struct {
+	Received    uint64       `json:"received"`
+	Acked       uint64       `json:"acked"`
+	Nacked      uint64       `json:"nacked"`
+	LastSeq     uint64       `json:"last_seq,omitempty"`
+	Breaker     BreakerState `json:"circuit_breaker,omitempty"`
+	LastError   string       `json:"last_error,omitempty"`
+	LastErrorAt *time.Time   `json:"last_error_at,omitempty"`
+}
+
+// lastError records the most recent error seen by a publisher or subscriber
+type lastError struct {
+	mu  sync.Mutex
+	err error
+	at  time.Time
+}
+
+func (l *lastError) set(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+	l.at = time.Now()
+}
+
+// get returns the last error message and when it occurred, or nil if no error was recorded
+func (l *lastError) get() (string, *time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		return "", nil
+	}
+	at := l.at
+	return l.err.Error(), &at
+}
@@ -2,117 +2,656 @@ package pubsub
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/nats-io/nats.go"
+	"github.com/fawadmazhar/nats-pubsub/internal/tracing"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ConnectionState reports the state of the underlying NATS connection
+type ConnectionState interface {
+	Connected() bool
+	Healthy() error
+	WaitConnected(ctx context.Context) error
+}
+
+// Subscriber defaults
+const (
+	DefaultGracePeriod    = 10 * time.Second // time in-flight handlers may run after shutdown starts
+	DefaultFetchMaxWait   = 5 * time.Second  // how long each pull request waits for messages
+	DefaultFetchMaxBytes  = 1024 * 1024      // upper bound on the payload buffered by a pull request
+	DefaultFetchHeartbeat = time.Second      // idle heartbeat interval of a pull request
+)
+
+// errConnectionClosed ends consumption once the NATS connection is closed for good
+var errConnectionClosed = errors.New("NATS connection closed")
+
+// Bounds of the delay before binding again after the message iterator fails
+const (
+	minFetchBackoff = 100 * time.Millisecond
+	maxFetchBackoff = 5 * time.Second
 )
 
 // Subscriber handles consuming messages from NATS JetStream
 type Subscriber struct {
-	js          nats.JetStreamContext
-	streamName  string
-	subjectName string
+	js           jetstream.JetStream
+	streamName   string
+	subjects     []string
+	conn         ConnectionState
+	handler      Handler
+	gracePeriod  time.Duration
+	ordered      bool
+	push         bool
+	deliverGroup string
+	keyFunc      KeyFunc
+	batchHandler BatchHandler
+	batchSize    int
+	batchWait    time.Duration
+	replay       *Replay
+	replayCfg    *jetstream.ConsumerConfig
+	options
+
+	fetchMaxWait   time.Duration
+	fetchMaxBytes  int
+	fetchHeartbeat time.Duration
+
+	claimDelete bool
+	middleware  []Middleware
+	breakerCfg  *BreakerConfig
+	breaker     *breaker
+	rateLimit   *RateLimitConfig
+	limiter     *dispatchLimiter
+
+	bound    atomic.Bool
+	received atomic.Uint64
+	acked    atomic.Uint64
+	nacked   atomic.Uint64
+	lastSeq  atomic.Uint64
+	lastErr  lastError
 }
 
-// NewSubscriber creates a new subscriber instance
-func NewSubscriber(js nats.JetStreamContext, streamName, subjectName string) *Subscriber {
-	return &Subscriber{
+// NewSubscriber creates a new subscriber instance consuming subjectName, or the
+// subjects given by WithFilterSubjects
+func NewSubscriber(js jetstream.JetStream, streamName, subjectName string, opts ...SubscriberOption) *Subscriber {
+	s := &Subscriber{
 		js:          js,
 		streamName:  streamName,
-		subjectName: subjectName,
+		subjects:    []string{subjectName},
+		handler:     LogMessage,
+		gracePeriod: DefaultGracePeriod,
+
+		fetchMaxWait:   DefaultFetchMaxWait,
+		fetchMaxBytes:  DefaultFetchMaxBytes,
+		fetchHeartbeat: DefaultFetchHeartbeat,
 	}
+	for _, opt := range opts {
+		opt.applySubscriber(s)
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	s.logger = s.logger.With("stream", streamName, "subject", strings.Join(s.subjects, ","))
+	s.handler = chain(s.handler, s.middleware...)
+	if s.rateLimit != nil {
+		s.limiter = newDispatchLimiter(*s.rateLimit)
+	}
+	if s.breakerCfg != nil {
+		s.breaker = newBreaker(*s.breakerCfg, s.breakerChanged)
+		s.metrics.setBreakerState(s.streamName, BreakerClosed)
+	}
+	return s
 }
 
-// Run starts the subscription process with the specified worker count
+// Healthy returns an error if the subscriber cannot currently receive messages
+func (s *Subscriber) Healthy() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Healthy()
+}
+
+// Bound reports whether the subscriber is currently bound to its consumer
+func (s *Subscriber) Bound() bool {
+	return s.bound.Load()
+}
+
+// Stats returns a snapshot of the subscriber's counters
+func (s *Subscriber) Stats() SubscriberStats {
+	lastErr, lastErrAt := s.lastErr.get()
+	stats := SubscriberStats{
+		Received:    s.received.Load(),
+		Acked:       s.acked.Load(),
+		Nacked:      s.nacked.Load(),
+		LastSeq:     s.lastSeq.Load(),
+		LastError:   lastErr,
+		LastErrorAt: lastErrAt,
+	}
+	if s.breaker != nil {
+		stats.Breaker = s.breaker.State()
+	}
+	return stats
+}
+
+// BreakerState returns the state of the circuit breaker, closed when it is
+// not enabled
+func (s *Subscriber) BreakerState() BreakerState {
+	return s.breaker.State()
+}
+
+// waitConnected blocks while the NATS connection is down, returning an error
+// if the connection is closed for good
+func (s *Subscriber) waitConnected(ctx context.Context) error {
+	if s.conn == nil || s.conn.Connected() {
+		return nil
+	}
+
+	s.logger.Warn("NATS connection lost, pausing fetching")
+	if err := s.conn.WaitConnected(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	s.logger.Info("NATS connection restored, resuming fetching")
+	return nil
+}
+
+// Run starts the subscription process with the specified worker count. When ctx
+// is cancelled, fetching stops, messages not yet picked up by a worker are nak'ed
+// for prompt redelivery and in-flight handlers are given the grace period to
+// finish before their context is cancelled. Run returns once every fetched
+// message has been settled. Messages are pulled unless push mode is enabled. In
+// ordered mode maxWorkers is ignored and messages are processed one at a time.
 func (s *Subscriber) Run(ctx context.Context, maxWorkers int) error {
-	// Create a pull subscription
-	sub, err := s.js.PullSubscribe(
-		s.subjectName,
-		fmt.Sprintf("%s-consumer", s.streamName),
-		nats.BindStream(s.streamName),
-	)
-	if err != nil {
-		return fmt.Errorf("error creating subscription: %w", err)
+	if s.replay != nil {
+		if err := s.replay.validate(); err != nil {
+			return err
+		}
+	}
+	if err := s.checkRetention(ctx); err != nil {
+		return err
+	}
+	if s.ordered {
+		if s.batchHandler != nil {
+			return errors.New("batch handlers are not supported in ordered mode")
+		}
+		return s.runOrdered(ctx)
 	}
-	defer sub.Unsubscribe()
+	if s.push && s.replay != nil {
+		return errors.New("replays are not supported in push mode")
+	}
+	if s.keyFunc != nil && s.limiter.keyed() {
+		return errors.New("per-key rate limits are not supported with ordering keys")
+	}
+	if maxWorkers < 1 {
+		return fmt.Errorf("invalid worker count %d", maxWorkers)
+	}
+	if s.batchHandler != nil && s.batchSize < 1 {
+		return fmt.Errorf("invalid batch size %d", s.batchSize)
+	}
+
+	// Handlers run on a context that survives ctx so in-flight messages can
+	// finish during the grace period
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
 
-	// Create a worker pool
+	// Create a worker pool. Each worker holds a slot per message it can take,
+	// one or a full batch, and a message is only taken from the iterator once a
+	// slot is free.
 	var wg sync.WaitGroup
-	workChan := make(chan *nats.Msg, maxWorkers)
+	capacity := maxWorkers
+	worker := s.worker
+	if s.batchHandler != nil {
+		capacity = maxWorkers * s.batchSize
+		worker = s.batchWorker
+	}
+	d := s.newDispatcher(maxWorkers, capacity)
+	slots := make(chan struct{}, capacity)
+	for i := 0; i < capacity; i++ {
+		slots <- struct{}{}
+	}
+	s.metrics.setWorkers(s.streamName, maxWorkers)
 
 	// Start workers
 	for i := 0; i < maxWorkers; i++ {
 		wg.Add(1)
-		go func(workerID int) {
+		go func(workerID int, queue <-chan jetstream.Msg) {
 			defer wg.Done()
-			s.worker(ctx, workerID, workChan)
-		}(i + 1)
+			worker(ctx, handlerCtx, workerID, queue, slots)
+		}(i+1, d.queue(i))
+	}
+
+	// Consume until ctx is cancelled, then let the workers settle what is left
+	var err error
+	if s.push {
+		err = s.consumePush(ctx, d, slots)
+	} else {
+		err = s.consume(ctx, capacity, d, slots)
+	}
+	d.close()
+	s.drain(ctx, waitDone(&wg), cancelHandlers)
+	return err
+}
+
+// checkRetention rejects modes the stream's retention does not support. A
+// workqueue stream deletes messages once acked and lets a single consumer read
+// each subject, so it can neither be replayed nor read in ordered mode, and
+// the durable consumer of the other of pull and push mode must be deleted
+// before switching.
+func (s *Subscriber) checkRetention(ctx context.Context) error {
+	stream, err := s.js.Stream(ctx, s.streamName)
+	if err != nil {
+		return fmt.Errorf("error checking stream info: %w", err)
+	}
+	if stream.CachedInfo().Config.Retention != jetstream.WorkQueuePolicy {
+		return nil
+	}
+
+	switch {
+	case s.ordered:
+		return fmt.Errorf("ordered mode requires limits or interest retention, stream %s uses workqueue", s.streamName)
+	case s.replay != nil:
+		return fmt.Errorf("replays require limits or interest retention, stream %s uses workqueue", s.streamName)
+	}
+	other, mode := s.pushConsumerName(), "pull"
+	if s.push {
+		other, mode = s.consumerName(), "push"
+	}
+	_, err = stream.Consumer(ctx, other)
+	switch {
+	case err == nil:
+		return fmt.Errorf("stream %s uses workqueue retention, delete consumer %s before switching to %s mode", s.streamName, other, mode)
+	case !errors.Is(err, jetstream.ErrConsumerNotFound):
+		return fmt.Errorf("error checking consumer info: %w", err)
+	}
+	return nil
+}
+
+// consumerName returns the name of the durable consumer shared by subscribers of the stream
+func (s *Subscriber) consumerName() string {
+	return fmt.Sprintf("%s-consumer", s.streamName)
+}
+
+// setFilter restricts the consumer to the subscribed subjects
+func (s *Subscriber) setFilter(cfg *jetstream.ConsumerConfig) {
+	if len(s.subjects) == 1 {
+		cfg.FilterSubject = s.subjects[0]
+		return
+	}
+	cfg.FilterSubjects = s.subjects
+}
+
+// consumer creates the durable consumer, or the ephemeral one of a replay, or
+// updates it if it already exists
+func (s *Subscriber) consumer(ctx context.Context) (jetstream.Consumer, error) {
+	cfg := jetstream.ConsumerConfig{
+		Durable:   s.consumerName(),
+		AckPolicy: jetstream.AckExplicitPolicy,
 	}
+	s.setFilter(&cfg)
+	if s.replay != nil {
+		var err error
+		if cfg, err = s.replayConfig(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	cons, err := s.js.CreateOrUpdateConsumer(ctx, s.streamName, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating consumer: %w", err)
+	}
+	return cons, nil
+}
+
+// pullOptions returns the iterator options, buffering at most one message per
+// worker slot, and fewer if the rate limit cannot dispatch them promptly
+func (s *Subscriber) pullOptions(capacity int) []jetstream.PullMessagesOpt {
+	capacity = s.limiter.fetchSize(capacity)
+	opts := []jetstream.PullMessagesOpt{
+		jetstream.PullExpiry(s.fetchMaxWait),
+		jetstream.WithMessagesErrOnMissingHeartbeat(true),
+	}
+	if s.fetchMaxBytes > 0 {
+		opts = append(opts, jetstream.PullMaxMessagesWithBytesLimit(capacity, s.fetchMaxBytes))
+	} else {
+		opts = append(opts, jetstream.PullMaxMessages(capacity))
+	}
+	if s.fetchHeartbeat > 0 {
+		opts = append(opts, jetstream.PullHeartbeat(s.fetchHeartbeat))
+	}
+	return opts
+}
 
-	// Main loop for fetching messages
+// consume binds to the consumer and dispatches messages to the workers until
+// ctx is cancelled, rebinding with a backoff if the iterator fails
+func (s *Subscriber) consume(ctx context.Context, capacity int, d *dispatcher, slots chan struct{}) error {
+	backoff := minFetchBackoff
 	for {
+		if err := s.waitConnected(ctx); err != nil {
+			return fmt.Errorf("%w: %w", errConnectionClosed, err)
+		}
+		if err := s.breaker.wait(ctx); err != nil {
+			return nil
+		}
+
+		err := s.iterate(ctx, capacity, d, slots)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errReplayDone) {
+			s.logger.Info("Replay complete")
+			return nil
+		}
+		if errors.Is(err, errBreakerOpen) {
+			backoff = minFetchBackoff
+			continue
+		}
+		if errors.Is(err, errConnectionClosed) {
+			return err
+		}
+
+		s.logger.Error("Error consuming messages", "error", err)
+		s.lastErr.set(err)
+
 		select {
 		case <-ctx.Done():
-			close(workChan)
-			wg.Wait()
 			return nil
-		default:
-			msgs, err := sub.Fetch(10, nats.Context(ctx))
-			if err != nil {
-				if err == context.Canceled {
-					continue
-				}
-				log.Printf("Error fetching messages: %v", err)
-				continue
-			}
-
-			for _, msg := range msgs {
-				select {
-				case <-ctx.Done():
-					return nil
-				case workChan <- msg:
-				}
-			}
+		case <-time.After(backoff):
 		}
+		backoff = min(2*backoff, maxFetchBackoff)
 	}
 }
 
-// worker processes messages from the work channel
-func (s *Subscriber) worker(ctx context.Context, id int, workChan <-chan *nats.Msg) {
-	log.Printf("Worker %d started", id)
+// iterate dispatches messages from a single iterator until ctx is cancelled or
+// the iterator fails. On cancellation the iterator is drained and the messages
+// it had already buffered are nak'ed.
+func (s *Subscriber) iterate(ctx context.Context, capacity int, d *dispatcher, slots chan struct{}) error {
+	cons, err := s.consumer(ctx)
+	if err != nil {
+		return err
+	}
+
+	it, err := cons.Messages(s.pullOptions(capacity)...)
+	if err != nil {
+		return fmt.Errorf("error creating message iterator: %w", err)
+	}
+	defer it.Stop()
+	stop := context.AfterFunc(ctx, it.Drain)
+	defer stop()
+
+	s.bound.Store(true)
+	defer s.bound.Store(false)
+
 	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("Worker %d shutting down", id)
-			return
-		case msg, ok := <-workChan:
-			if !ok {
-				log.Printf("Worker %d channel closed", id)
-				return
+		if err := s.waitConnected(ctx); err != nil {
+			return fmt.Errorf("%w: %w", errConnectionClosed, err)
+		}
+
+		// Wait for an idle worker unless shutting down, leaving messages in
+		// the stream meanwhile
+		claimed := false
+		if ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-slots:
+				claimed = true
 			}
+		}
 
-			// Process the message
-			var message Message
-			if err := json.Unmarshal(msg.Data, &message); err != nil {
-				log.Printf("Worker %d - Error unmarshaling message: %v", id, err)
-				msg.Nak()
+		msg, err := it.Next()
+		if err != nil {
+			if claimed {
+				slots <- struct{}{}
+			}
+			switch {
+			case errors.Is(err, jetstream.ErrNoHeartbeat):
+				s.logger.Warn("No heartbeat received from server, retrying", "error", err)
 				continue
+			case errors.Is(err, jetstream.ErrMsgIteratorClosed) && ctx.Err() != nil:
+				return nil
+			default:
+				return fmt.Errorf("error fetching message: %w", err)
 			}
+		}
 
-			// Log message details
-			log.Printf("Worker %d - Received message: %s, Content: %s, Timestamp: %v",
-				id, message.ID, message.Content, message.Timestamp)
+		// Hand back the messages buffered once shutdown started
+		if ctx.Err() != nil {
+			s.settle(s.logger.With(messageAttrs(msg)...), msg, outcomeNak)
+			continue
+		}
 
-			// Simulate some processing time
-			// time.Sleep(100 * time.Millisecond)
+		// Stop a replay at its end bound
+		last := false
+		if s.replay != nil {
+			meta, err := msg.Metadata()
+			if err != nil {
+				slots <- struct{}{}
+				return fmt.Errorf("error reading message metadata: %w", err)
+			}
+			if s.replay.beyond(meta) {
+				slots <- struct{}{}
+				return errReplayDone
+			}
+			last = s.replay.last(meta)
+		}
+
+		// Hand back messages whose key is over its rate limit until it has a
+		// token again
+		if s.deferByKey(msg) {
+			slots <- struct{}{}
+			continue
+		}
 
-			// Acknowledge the message
-			if err := msg.Ack(); err != nil {
-				log.Printf("Worker %d - Error acknowledging message: %v", id, err)
+		// Wait for the global rate limit, handing the message back if
+		// shutdown starts first
+		if err := s.throttle(ctx); err != nil {
+			s.settle(s.logger.With(messageAttrs(msg)...), msg, outcomeNak)
+			slots <- struct{}{}
+			continue
+		}
+
+		// Stop fetching once the circuit breaker opens, handing back the
+		// buffered messages
+		admitted, err := s.admitPulled(ctx, msg)
+		if !admitted {
+			slots <- struct{}{}
+			if err != nil {
+				s.nakBuffered(it)
+				return err
 			}
+			continue
+		}
+
+		s.dispatch(d, msg)
+		if last {
+			return errReplayDone
 		}
 	}
 }
+
+// waitDone returns a channel closed once the wait group is done
+func waitDone(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// drain waits until done is closed. Once ctx is cancelled, the handlers are
+// given the grace period to finish before their context is cancelled.
+func (s *Subscriber) drain(ctx context.Context, done <-chan struct{}, cancelHandlers context.CancelFunc) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	timer := time.NewTimer(s.gracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		s.logger.Warn("Shutdown grace period expired, cancelling in-flight handlers", "grace_period", s.gracePeriod)
+		cancelHandlers()
+		<-done
+	}
+}
+
+// worker processes messages from the work channel until it is closed, freeing
+// its slot after each one. Once ctx is cancelled, remaining messages are nak'ed
+// instead of processed.
+func (s *Subscriber) worker(ctx, handlerCtx context.Context, id int, workChan <-chan jetstream.Msg, slots chan<- struct{}) {
+	logger := s.logger.With("worker_id", id)
+	logger.Info("Worker started")
+	defer logger.Info("Worker stopped")
+
+	for msg := range workChan {
+		s.metrics.addInFlight(s.streamName, -1)
+
+		if ctx.Err() != nil {
+			s.settle(logger.With(messageAttrs(msg)...), msg, outcomeNak)
+		} else {
+			s.metrics.addBusyWorkers(s.streamName, 1)
+			s.process(handlerCtx, logger, msg)
+			s.metrics.addBusyWorkers(s.streamName, -1)
+		}
+		slots <- struct{}{}
+	}
+}
+
+// process handles a single message and settles it according to the result
+func (s *Subscriber) process(ctx context.Context, logger *slog.Logger, msg jetstream.Msg) {
+	s.received.Add(1)
+	start := time.Now()
+	logger = logger.With(messageAttrs(msg)...)
+
+	// Hand back messages queued before the circuit breaker opened
+	if s.breaker.State() == BreakerOpen {
+		s.settleAfter(logger, msg, outcomeNak, s.breaker.cfg.OpenTimeout)
+		return
+	}
+
+	// Continue the trace started by the publisher
+	ctx, span := tracing.StartConsumerSpan(ctx, msg.Subject(), msg.Headers(), s.spanAttributes(msg)...)
+	defer span.End()
+
+	// Invalid payloads will not become valid, so they are not redelivered
+	prepared, err := s.prepare(ctx, logger, msg)
+	if err != nil {
+		logger.Error("Error preparing message", "error", err)
+		tracing.RecordError(span, err)
+		s.lastErr.set(err)
+		s.settleAfter(logger, msg, rejectOutcome(err), retryDelay(err))
+		s.breaker.skip()
+		return
+	}
+
+	err = s.handler(withLogger(ctx, logger), prepared)
+	s.recordResult(err)
+	if err != nil {
+		logger.Error("Error handling message", "error", err)
+		tracing.RecordError(span, err)
+		s.lastErr.set(err)
+		s.metrics.observeHandler(s.streamName, outcomeNak, time.Since(start))
+		s.settleAfter(logger, msg, outcomeNak, retryDelay(err))
+		return
+	}
+
+	s.metrics.observeHandler(s.streamName, outcomeAck, time.Since(start))
+	s.settle(logger, msg, outcomeAck)
+}
+
+// prepare fetches claim-checked payloads, then decrypts, decompresses and
+// validates a message before it is handled. It returns an error for messages
+// to reject, retryable if the message may succeed on redelivery.
+func (s *Subscriber) prepare(ctx context.Context, logger *slog.Logger, msg jetstream.Msg) (jetstream.Msg, error) {
+	msg, err := s.claim(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	msg, err = s.decrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+	msg, err = s.decompress(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSchema(ctx, logger, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// messageAttrs returns the log attributes identifying a JetStream message
+func messageAttrs(msg jetstream.Msg) []any {
+	attrs := []any{"msg_id", msg.Headers().Get(jetstream.MsgIDHeader)}
+	if meta, err := msg.Metadata(); err == nil {
+		attrs = append(attrs,
+			"seq", meta.Sequence.Stream,
+			"delivery_count", meta.NumDelivered,
+		)
+	}
+	return attrs
+}
+
+// spanAttributes describes the JetStream delivery of a message
+func (s *Subscriber) spanAttributes(msg jetstream.Msg) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.nats.stream", s.streamName),
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		attrs = append(attrs,
+			attribute.String("messaging.nats.consumer", meta.Consumer),
+			attribute.Int64("messaging.nats.sequence", int64(meta.Sequence.Stream)),
+			attribute.Int64("messaging.nats.delivery_count", int64(meta.NumDelivered)),
+		)
+	}
+	return attrs
+}
+
+// settle acknowledges, naks or terminates the message and records the outcome
+func (s *Subscriber) settle(logger *slog.Logger, msg jetstream.Msg, outcome string) {
+	s.settleAfter(logger, msg, outcome, 0)
+}
+
+// settleAfter settles the message, asking for nak'ed messages to be redelivered
+// after the delay
+func (s *Subscriber) settleAfter(logger *slog.Logger, msg jetstream.Msg, outcome string, delay time.Duration) {
+	start := time.Now()
+
+	var err error
+	switch {
+	case outcome == outcomeAck:
+		err = s.ack(msg)
+	case outcome == outcomeNak && delay > 0:
+		err = msg.NakWithDelay(delay)
+	case outcome == outcomeNak:
+		err = msg.Nak()
+	case outcome == outcomeTerm:
+		err = msg.Term()
+	}
+	if err != nil {
+		logger.Error("Error settling message", "outcome", outcome, "error", err)
+		s.lastErr.set(err)
+		return
+	}
+
+	s.metrics.observeOutcome(s.streamName, outcome, time.Since(start))
+	if outcome == outcomeAck {
+		s.acked.Add(1)
+		s.release(msg)
+	} else {
+		s.nacked.Add(1)
+	}
+}
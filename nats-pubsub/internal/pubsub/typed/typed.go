@@ -0,0 +1,72 @@
+// Package typed offers a generic publish/subscribe API on top of the pubsub
+// package, encoding and decoding values of one type with the configured codec.
+package typed
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/pubsub"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Publisher publishes values of type T
+type Publisher[T any] struct {
+	*pubsub.Publisher
+}
+
+// NewPublisher creates a publisher of T values, encoded with the codec set by
+// pubsub.WithCodec
+func NewPublisher[T any](js jetstream.JetStream, subjectName string, opts ...pubsub.PublisherOption) *Publisher[T] {
+	return &Publisher[T]{Publisher: pubsub.NewPublisher(js, subjectName, opts...)}
+}
+
+// Publish encodes and publishes a value. A non-empty id is used for deduplication.
+func (p *Publisher[T]) Publish(ctx context.Context, id string, v T) error {
+	return p.PublishValue(ctx, id, v)
+}
+
+// Handler processes a decoded value along with the message carrying it
+type Handler[T any] func(ctx context.Context, v T, msg jetstream.Msg) error
+
+// Handle adapts a typed handler to a pubsub.Handler, decoding each message with
+// the codec matching its content type header. Pointer types are allocated
+// before decoding, as generated Protocol Buffers types require.
+func Handle[T any](h Handler[T]) pubsub.Handler {
+	return func(ctx context.Context, msg jetstream.Msg) error {
+		v := newValue[T]()
+		if err := pubsub.Decode(msg, target(&v)); err != nil {
+			return err
+		}
+		return h(ctx, v, msg)
+	}
+}
+
+// Subscriber consumes values of type T
+type Subscriber[T any] struct {
+	*pubsub.Subscriber
+}
+
+// NewSubscriber creates a subscriber passing decoded T values to the handler
+func NewSubscriber[T any](js jetstream.JetStream, streamName, subjectName string, h Handler[T], opts ...pubsub.SubscriberOption) *Subscriber[T] {
+	opts = append(opts, pubsub.WithHandler(Handle(h)))
+	return &Subscriber[T]{Subscriber: pubsub.NewSubscriber(js, streamName, subjectName, opts...)}
+}
+
+// newValue returns the zero T, or a pointer to a new element when T is a pointer type
+func newValue[T any]() T {
+	var v T
+	if t := reflect.TypeFor[T](); t.Kind() == reflect.Pointer {
+		v = reflect.New(t.Elem()).Interface().(T)
+	}
+	return v
+}
+
+// target returns what to decode into: the pointer itself when T is a pointer
+// type, so that codecs needing the concrete type such as protobuf see it
+func target[T any](v *T) any {
+	if reflect.TypeFor[T]().Kind() == reflect.Pointer {
+		return *v
+	}
+	return v
+}
@@ -0,0 +1,157 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Compatibility selects the check made when registering a new schema version
+type Compatibility string
+
+const (
+	CompatNone     Compatibility = "none"
+	CompatBackward Compatibility = "backward" // the new schema accepts payloads valid under the latest one
+	CompatForward  Compatibility = "forward"  // the latest schema accepts payloads valid under the new one
+	CompatFull     Compatibility = "full"     // both backward and forward
+)
+
+// ParseCompatibility parses a compatibility name
+func ParseCompatibility(name string) (Compatibility, error) {
+	switch c := Compatibility(strings.ToLower(name)); c {
+	case CompatNone, CompatBackward, CompatForward, CompatFull:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown schema compatibility %q", name)
+	}
+}
+
+// check compares the latest and the new schema documents. The comparison
+// covers the keywords describing a payload's shape: type, properties,
+// required, additionalProperties, items and enum.
+func (c Compatibility) check(latest, next map[string]any) error {
+	var issues []string
+	if c == CompatBackward || c == CompatFull {
+		issues = append(issues, readable(next, latest, "")...)
+	}
+	if c == CompatForward || c == CompatFull {
+		issues = append(issues, readable(latest, next, "")...)
+	}
+	if len(issues) > 0 {
+		return errors.New(strings.Join(issues, "; "))
+	}
+	return nil
+}
+
+// readable returns the reasons payloads valid under the writer schema may be
+// rejected by the reader schema
+func readable(reader, writer map[string]any, path string) []string {
+	var issues []string
+	at := func(format string, args ...any) {
+		where := "root"
+		if path != "" {
+			where = path
+		}
+		issues = append(issues, where+": "+fmt.Sprintf(format, args...))
+	}
+
+	// Every type the writer allows must be allowed by the reader
+	readerTypes, writerTypes := types(reader), types(writer)
+	if len(readerTypes) > 0 {
+		if len(writerTypes) == 0 {
+			at("type restricted to %s", strings.Join(readerTypes, ", "))
+		}
+		for _, t := range writerTypes {
+			if !slices.Contains(readerTypes, t) && !(t == "integer" && slices.Contains(readerTypes, "number")) {
+				at("type %s no longer allowed", t)
+			}
+		}
+	}
+
+	// Every value the writer allows must be in the reader's enum
+	if readerEnum, ok := reader["enum"].([]any); ok {
+		writerEnum, ok := writer["enum"].([]any)
+		if !ok {
+			at("values restricted to an enum")
+		}
+		for _, v := range writerEnum {
+			if !slices.ContainsFunc(readerEnum, func(r any) bool { return reflect.DeepEqual(r, v) }) {
+				at("enum value %v no longer allowed", v)
+			}
+		}
+	}
+
+	// The writer must always set the properties the reader requires
+	writerRequired := stringList(writer["required"])
+	for _, name := range stringList(reader["required"]) {
+		if !slices.Contains(writerRequired, name) {
+			at("property %s is required but may be missing", name)
+		}
+	}
+
+	readerProps, _ := reader["properties"].(map[string]any)
+	writerProps, _ := writer["properties"].(map[string]any)
+	for _, name := range slices.Sorted(maps.Keys(writerProps)) {
+		wp := writerProps[name]
+		rp, ok := readerProps[name]
+		if !ok {
+			if reader["additionalProperties"] == false {
+				at("property %s is not allowed", name)
+			}
+			continue
+		}
+		rs, rok := rp.(map[string]any)
+		ws, wok := wp.(map[string]any)
+		if rok && wok {
+			issues = append(issues, readable(rs, ws, join(path, name))...)
+		}
+	}
+
+	if ri, ok := reader["items"].(map[string]any); ok {
+		if wi, ok := writer["items"].(map[string]any); ok {
+			issues = append(issues, readable(ri, wi, path+"[]")...)
+		}
+	}
+
+	return issues
+}
+
+// types returns the types allowed by a schema, empty when unrestricted
+func types(s map[string]any) []string {
+	switch t := s["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		return stringList(t)
+	default:
+		return nil
+	}
+}
+
+// stringList returns the string elements of a JSON array
+func stringList(v any) []string {
+	arr, _ := v.([]any)
+	out := make([]string, 0, len(arr))
+	for _, e := range arr {
+		if s, ok := e.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// join appends a property name to a dotted path
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// equalDocs reports whether two schema documents are identical
+func equalDocs(a, b map[string]any) bool {
+	return reflect.DeepEqual(a, b)
+}
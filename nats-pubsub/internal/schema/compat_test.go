@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func doc(t *testing.T, raw string) map[string]any {
+	t.Helper()
+	var d map[string]any
+	if err := json.Unmarshal([]byte(raw), &d); err != nil {
+		t.Fatalf("invalid schema %s: %v", raw, err)
+	}
+	return d
+}
+
+const orderV1 = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "string"},
+		"status": {"enum": ["new", "paid"]},
+		"customer": {"type": "object", "properties": {"id": {"type": "integer"}}},
+		"items": {"type": "array", "items": {"type": "object", "properties": {"sku": {"type": "string"}}}}
+	},
+	"required": ["id"]
+}`
+
+func TestCompatibilityCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		next     string
+		backward string // substring of the backward issue, empty when compatible
+		forward  string // substring of the forward issue, empty when compatible
+	}{
+		{
+			name: "unchanged",
+			next: orderV1,
+		},
+		{
+			name: "optional property added",
+			next: `{"type": "object", "properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id"]}`,
+		},
+		{
+			name:     "required property added",
+			next:     `{"type": "object", "properties": {"id": {"type": "string"}, "total": {"type": "number"}}, "required": ["id", "total"]}`,
+			backward: "property total is required but may be missing",
+		},
+		{
+			name:    "required property dropped",
+			next:    `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			forward: "property id is required but may be missing",
+		},
+		{
+			name:     "additional properties closed",
+			next:     `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"], "additionalProperties": false}`,
+			backward: "property customer is not allowed",
+		},
+		{
+			name:    "type widened",
+			next:    `{"type": "object", "properties": {"id": {"type": ["string", "null"]}}, "required": ["id"]}`,
+			forward: "id: type null no longer allowed",
+		},
+		{
+			name:    "integer widened to number",
+			next:    `{"type": "object", "properties": {"customer": {"type": "object", "properties": {"id": {"type": "number"}}}}, "required": ["id"]}`,
+			forward: "customer.id: type number no longer allowed",
+		},
+		{
+			name:     "enum narrowed",
+			next:     `{"type": "object", "properties": {"status": {"enum": ["new"]}}, "required": ["id"]}`,
+			backward: "status: enum value paid no longer allowed",
+		},
+		{
+			name:     "array item type changed",
+			next:     `{"type": "object", "properties": {"items": {"type": "array", "items": {"type": "object", "properties": {"sku": {"type": "integer"}}}}}, "required": ["id"]}`,
+			backward: "items[].sku: type string no longer allowed",
+			forward:  "items[].sku: type integer no longer allowed",
+		},
+		{
+			name:     "root type restricted",
+			next:     `{"type": "string"}`,
+			backward: "root: type object no longer allowed",
+			forward:  "root: type string no longer allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latest, next := doc(t, orderV1), doc(t, tt.next)
+
+			check := func(c Compatibility, want string) {
+				t.Helper()
+				err := c.check(latest, next)
+				switch {
+				case want == "" && err != nil:
+					t.Errorf("%s check error = %v, want compatible", c, err)
+				case want != "" && err == nil:
+					t.Errorf("%s check passed, want %q", c, want)
+				case want != "" && !strings.Contains(err.Error(), want):
+					t.Errorf("%s check error = %v, want %q", c, err, want)
+				}
+			}
+			check(CompatBackward, tt.backward)
+			check(CompatForward, tt.forward)
+			if err := CompatNone.check(latest, next); err != nil {
+				t.Errorf("none check error = %v", err)
+			}
+			if err := CompatFull.check(latest, next); (err != nil) != (tt.backward != "" || tt.forward != "") {
+				t.Errorf("full check error = %v, want an error only if backward or forward fails", err)
+			}
+		})
+	}
+}
+
+func TestParseCompatibility(t *testing.T) {
+	for _, name := range []string{"none", "backward", "FORWARD", "Full"} {
+		if _, err := ParseCompatibility(name); err != nil {
+			t.Errorf("ParseCompatibility(%q) error = %v", name, err)
+		}
+	}
+	if _, err := ParseCompatibility("transitive"); err == nil {
+		t.Errorf("ParseCompatibility(%q) succeeded, want an error", "transitive")
+	}
+}
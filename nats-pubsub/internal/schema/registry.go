@@ -0,0 +1,317 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/codec"
+	"github.com/fawadmazhar/nats-pubsub/internal/config"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// VersionHeader is the message header carrying the schema version a payload
+// was validated against
+const VersionHeader = "Schema-Version"
+
+// DefaultBucket is the KV bucket holding the schemas
+const DefaultBucket = "SCHEMAS"
+
+// ErrInvalidSchema is returned for a stored schema that does not compile
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// Mode selects what happens to a payload failing validation
+type Mode string
+
+const (
+	ModeReject Mode = "reject" // refuse to publish, terminate on consume
+	ModeWarn   Mode = "warn"   // log a warning and carry on
+)
+
+// ParseMode parses a validation mode name
+func ParseMode(name string) (Mode, error) {
+	switch m := Mode(strings.ToLower(name)); m {
+	case ModeReject, ModeWarn:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown schema mode %q", name)
+	}
+}
+
+// Schema is a registered JSON Schema version of a subject
+type Schema struct {
+	Subject string
+	Version int
+
+	doc      map[string]any
+	compiled *jsonschema.Schema
+}
+
+// Validate checks a payload encoded with the given content type. Payloads are
+// mapped onto the JSON data model, so Protocol Buffers cannot be validated.
+func (s *Schema) Validate(contentType string, data []byte) error {
+	c, err := codec.ForContentType(contentType)
+	if err != nil {
+		return err
+	}
+	if c == codec.Protobuf {
+		return errors.New("protobuf payloads cannot be validated against a JSON Schema")
+	}
+
+	// Re-encode other codecs as JSON, which the validator expects
+	if c != codec.JSON {
+		var v any
+		if err := c.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("error unmarshaling message: %w", err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("error converting message to JSON: %w", err)
+		}
+	}
+
+	v, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error unmarshaling message: %w", err)
+	}
+	return s.compiled.Validate(v)
+}
+
+// Registry stores JSON Schemas per subject and version in a KV bucket, under
+// the key <subject>.<version>. Registered schemas are immutable and kept in a
+// local cache that a watch on the bucket keeps up to date.
+type Registry struct {
+	kv     jetstream.KeyValue
+	compat Compatibility
+
+	mu       sync.RWMutex
+	versions map[string]map[int]*Schema
+	latest   map[string]*Schema
+}
+
+// NewRegistry opens or creates the bucket and loads the registered schemas.
+// The cache stops being updated once ctx is cancelled.
+func NewRegistry(ctx context.Context, js jetstream.JetStream, bucket string, compat Compatibility) (*Registry, error) {
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "JSON Schemas per subject and version",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema bucket: %w", err)
+	}
+
+	r := &Registry{
+		kv:       kv,
+		compat:   compat,
+		versions: make(map[string]map[int]*Schema),
+		latest:   make(map[string]*Schema),
+	}
+
+	w, err := kv.WatchAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error watching schema bucket: %w", err)
+	}
+	// Stopping the watcher closes its updates channel
+	context.AfterFunc(ctx, func() { _ = w.Stop() })
+
+	// The watcher sends a nil entry once the existing schemas have been delivered
+	for entry := range w.Updates() {
+		if entry == nil {
+			break
+		}
+		r.store(entry)
+	}
+	go func() {
+		for entry := range w.Updates() {
+			if entry != nil {
+				r.store(entry)
+			}
+		}
+	}()
+
+	return r, nil
+}
+
+// Setup opens the registry configured by cfg, returning nil when no bucket is set
+func Setup(ctx context.Context, js jetstream.JetStream, cfg config.SchemaConfig) (*Registry, error) {
+	if cfg.Bucket == "" {
+		return nil, nil
+	}
+	compat, err := ParseCompatibility(cfg.Compatibility)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegistry(ctx, js, cfg.Bucket, compat)
+}
+
+// Latest returns the newest schema version of a subject
+func (r *Registry) Latest(subject string) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.latest[subject]
+	return s, ok
+}
+
+// Version returns a specific schema version of a subject
+func (r *Registry) Version(subject string, version int) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.versions[subject][version]
+	return s, ok
+}
+
+// Fetch reads a schema version of a subject from the bucket and caches it, for
+// versions registered too recently to have reached the cache through the
+// watch. It returns nil if the version does not exist, and an error wrapping
+// ErrInvalidSchema if the stored schema does not compile.
+func (r *Registry) Fetch(ctx context.Context, subject string, version int) (*Schema, error) {
+	entry, err := r.kv.Get(ctx, key(subject, version))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema version %d: %w", version, err)
+	}
+	s, err := compile(subject, version, entry.Value())
+	if err != nil {
+		return nil, fmt.Errorf("%w version %d: %w", ErrInvalidSchema, version, err)
+	}
+	r.add(s)
+	return s, nil
+}
+
+// Register stores a schema as the next version of a subject after checking it
+// is compatible with the latest version. Registering a schema identical to the
+// latest version returns that version.
+func (r *Registry) Register(ctx context.Context, subject string, raw []byte) (int, error) {
+	s, err := compile(subject, 0, raw)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read the latest version from the bucket rather than the cache, so that a
+	// schema registered elsewhere a moment ago is taken into account
+	latest, err := r.fetchLatest(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+	if latest != nil {
+		if equalDocs(latest.doc, s.doc) {
+			return latest.Version, nil
+		}
+		if err := r.compat.check(latest.doc, s.doc); err != nil {
+			return 0, fmt.Errorf("schema incompatible with version %d: %w", latest.Version, err)
+		}
+		s.Version = latest.Version + 1
+	} else {
+		s.Version = 1
+	}
+
+	// Create fails if another registration took the version in the meantime
+	if _, err := r.kv.Create(ctx, key(subject, s.Version), raw); err != nil {
+		return 0, fmt.Errorf("error storing schema version %d: %w", s.Version, err)
+	}
+	r.add(s)
+	return s.Version, nil
+}
+
+// fetchLatest returns the newest version of a subject stored in the bucket, or
+// nil if there is none
+func (r *Registry) fetchLatest(ctx context.Context, subject string) (*Schema, error) {
+	lister, err := r.kv.ListKeysFiltered(ctx, subject+".*")
+	if err != nil {
+		return nil, fmt.Errorf("error listing schema versions: %w", err)
+	}
+	defer lister.Stop()
+
+	newest := 0
+	for k := range lister.Keys() {
+		if _, v, ok := parseKey(k); ok {
+			newest = max(newest, v)
+		}
+	}
+	if newest == 0 {
+		return nil, nil
+	}
+
+	entry, err := r.kv.Get(ctx, key(subject, newest))
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema version %d: %w", newest, err)
+	}
+	return compile(subject, newest, entry.Value())
+}
+
+// store caches a schema received from the bucket watch
+func (r *Registry) store(entry jetstream.KeyValueEntry) {
+	if entry.Operation() != jetstream.KeyValuePut {
+		return
+	}
+	subject, version, ok := parseKey(entry.Key())
+	if !ok {
+		return
+	}
+	s, err := compile(subject, version, entry.Value())
+	if err != nil {
+		return
+	}
+	r.add(s)
+}
+
+// add caches a schema, moving the subject's latest version forward if newer
+func (r *Registry) add(s *Schema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.versions[s.Subject] == nil {
+		r.versions[s.Subject] = make(map[int]*Schema)
+	}
+	r.versions[s.Subject][s.Version] = s
+	if cur, ok := r.latest[s.Subject]; !ok || s.Version > cur.Version {
+		r.latest[s.Subject] = s
+	}
+}
+
+// compile parses and compiles a JSON Schema document
+func compile(subject string, version int, raw []byte) (*Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schema: %w", err)
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil, errors.New("schema must be a JSON object")
+	}
+
+	url := fmt.Sprintf("urn:schema:%s:%d", subject, version)
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(url, doc); err != nil {
+		return nil, fmt.Errorf("error loading schema: %w", err)
+	}
+	compiled, err := c.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling schema: %w", err)
+	}
+	return &Schema{Subject: subject, Version: version, doc: obj, compiled: compiled}, nil
+}
+
+// key returns the bucket key of a schema version
+func key(subject string, version int) string {
+	return subject + "." + strconv.Itoa(version)
+}
+
+// parseKey splits a bucket key into its subject and version
+func parseKey(k string) (string, int, bool) {
+	i := strings.LastIndexByte(k, '.')
+	if i < 0 {
+		return "", 0, false
+	}
+	v, err := strconv.Atoi(k[i+1:])
+	if err != nil || v < 1 {
+		return "", 0, false
+	}
+	return k[:i], v, true
+}
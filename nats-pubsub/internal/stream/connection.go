@@ -0,0 +1,180 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrConnectionClosed is returned once the NATS connection has been closed for good
+var ErrConnectionClosed = errors.New("NATS connection closed")
+
+// Connection wraps a NATS connection and its JetStream context, tracking
+// connection lifecycle events reported by the client
+type Connection struct {
+	NC *nats.Conn
+	JS jetstream.JetStream
+
+	logger     *slog.Logger
+	mu         sync.Mutex
+	changed    chan struct{} // closed and replaced on every lifecycle event
+	closed     chan struct{} // closed once the connection is closed for good
+	closeOnce  sync.Once
+	lastErr    error
+	reconnects int
+}
+
+func newConnection(logger *slog.Logger) *Connection {
+	return &Connection{
+		logger:  logger,
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+// handlerOptions returns the lifecycle handlers that keep the connection state up to date
+func (c *Connection) handlerOptions() []nats.Option {
+	return []nats.Option{
+		nats.ConnectHandler(func(nc *nats.Conn) {
+			c.logger.Info("Connected to NATS", "url", nc.ConnectedUrlRedacted())
+			c.notify(nil)
+		}),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			if err != nil {
+				c.logger.Warn("Disconnected from NATS", "error", err)
+			} else {
+				c.logger.Info("Disconnected from NATS")
+			}
+			c.notify(err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			c.mu.Lock()
+			c.reconnects++
+			reconnects := c.reconnects
+			c.mu.Unlock()
+			c.logger.Info("Reconnected to NATS", "url", nc.ConnectedUrlRedacted(), "reconnects", reconnects)
+			c.notify(nil)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			if err := nc.LastError(); err != nil {
+				c.logger.Error("NATS connection closed", "error", err)
+				c.notify(err)
+			} else {
+				c.logger.Info("NATS connection closed")
+				c.notify(nil)
+			}
+			c.closeOnce.Do(func() { close(c.closed) })
+		}),
+		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			if sub != nil {
+				c.logger.Error("NATS async error", "subject", sub.Subject, "error", err)
+			} else {
+				c.logger.Error("NATS async error", "error", err)
+			}
+			c.notify(err)
+		}),
+	}
+}
+
+// notify records the latest error, if any, and wakes up goroutines waiting for a state change
+func (c *Connection) notify(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.lastErr = err
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// ConnectionStats is a snapshot of the connection state
+type ConnectionStats struct {
+	Status     string `json:"status"`
+	URL        string `json:"url,omitempty"`
+	Reconnects int    `json:"reconnects"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// Stats returns a snapshot of the connection state
+func (c *Connection) Stats() ConnectionStats {
+	stats := ConnectionStats{
+		Status:     c.NC.Status().String(),
+		URL:        c.NC.ConnectedUrlRedacted(),
+		Reconnects: c.Reconnects(),
+	}
+	if err := c.LastError(); err != nil {
+		stats.LastError = err.Error()
+	}
+	return stats
+}
+
+// Close closes the underlying NATS connection
+func (c *Connection) Close() {
+	c.NC.Close()
+}
+
+// Closed returns a channel that is closed once the connection is closed for good,
+// either explicitly or because the reconnect attempts were exhausted
+func (c *Connection) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Connected reports whether the connection is currently established
+func (c *Connection) Connected() bool {
+	return c.NC.IsConnected()
+}
+
+// Healthy returns an error describing the connection state if it is not connected
+func (c *Connection) Healthy() error {
+	if c.NC.IsConnected() {
+		return nil
+	}
+	if err := c.LastError(); err != nil {
+		return fmt.Errorf("NATS connection %s: %w", c.NC.Status(), err)
+	}
+	return fmt.Errorf("NATS connection %s", c.NC.Status())
+}
+
+// LastError returns the most recent connection error reported by the client
+func (c *Connection) LastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// Reconnects returns the number of successful reconnections
+func (c *Connection) Reconnects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnects
+}
+
+// WaitConnected blocks until the connection is established, the connection is
+// closed for good or the context is cancelled
+func (c *Connection) WaitConnected(ctx context.Context) error {
+	for {
+		// Grab the change channel before checking the status so no event is missed
+		c.mu.Lock()
+		changed := c.changed
+		c.mu.Unlock()
+
+		if c.NC.IsConnected() {
+			return nil
+		}
+		if c.NC.IsClosed() {
+			return ErrConnectionClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
@@ -1,88 +1,132 @@
 package stream
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
-	"github.com/nats-io/nats.go"
 	"github.com/fawadmazhar/nats-pubsub/internal/config"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
-// Connect establishes a connection to NATS and returns JetStream context
-func Connect(url string) (nats.JetStreamContext, *nats.Conn, error) {
-	// Connect to NATS
-	nc, err := nats.Connect(url,
+// Connect establishes a connection to NATS and returns it along with its JetStream context.
+// Connection lifecycle events are logged to the given logger.
+func Connect(cfg config.NatsConfig, logger *slog.Logger) (*Connection, error) {
+	// Build TLS and authentication options
+	opts, err := connectOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring NATS connection: %w", err)
+	}
+
+	// Connect to NATS, tracking lifecycle events on the returned connection
+	conn := newConnection(logger)
+	opts = append(opts, conn.handlerOptions()...)
+	opts = append(opts,
 		nats.RetryOnFailedConnect(true),
-		nats.MaxReconnects(5),
-		nats.ReconnectWait(time.Second),
+		nats.MaxReconnects(cfg.Reconnect.MaxReconnects),
+		nats.ReconnectWait(cfg.Reconnect.Wait),
+		nats.ReconnectJitter(cfg.Reconnect.Jitter, cfg.Reconnect.JitterTLS),
+		nats.ReconnectBufSize(cfg.Reconnect.BufSize),
 	)
+	nc, err := nats.Connect(cfg.URL, opts...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error connecting to NATS: %w", err)
+		return nil, fmt.Errorf("error connecting to NATS: %w", err)
 	}
+	conn.NC = nc
 
 	// Create JetStream Context
-	js, err := nc.JetStream()
+	js, err := jetstream.New(nc)
 	if err != nil {
 		nc.Close()
-		return nil, nil, fmt.Errorf("error creating JetStream context: %w", err)
+		return nil, fmt.Errorf("error creating JetStream context: %w", err)
 	}
+	conn.JS = js
 
-	return js, nc, nil
+	return conn, nil
 }
 
-// getRetentionPolicy converts string to nats.RetentionPolicy
-func getRetentionPolicy(retention string) nats.RetentionPolicy {
+// getRetentionPolicy converts string to jetstream.RetentionPolicy
+func getRetentionPolicy(retention string) jetstream.RetentionPolicy {
 	switch strings.ToLower(retention) {
 	case "limits":
-		return nats.LimitsPolicy
+		return jetstream.LimitsPolicy
 	case "interest":
-		return nats.InterestPolicy
+		return jetstream.InterestPolicy
 	case "workqueue":
-		return nats.WorkQueuePolicy
+		return jetstream.WorkQueuePolicy
 	default:
-		return nats.LimitsPolicy
+		return jetstream.LimitsPolicy
 	}
 }
 
-// getStorageType converts string to nats.StorageType
-func getStorageType(storage string) nats.StorageType {
+// getStorageType converts string to jetstream.StorageType
+func getStorageType(storage string) jetstream.StorageType {
 	switch strings.ToLower(storage) {
 	case "file":
-		return nats.FileStorage
+		return jetstream.FileStorage
 	case "memory":
-		return nats.MemoryStorage
+		return jetstream.MemoryStorage
 	default:
-		return nats.FileStorage
+		return jetstream.FileStorage
 	}
 }
 
 // Setup creates the stream if it doesn't exist
-func Setup(js nats.JetStreamContext, cfg config.StreamConfig) error {
+func Setup(ctx context.Context, js jetstream.JetStream, cfg config.StreamConfig) error {
 	// Check if the stream already exists
-	stream, err := js.StreamInfo(cfg.Name)
-	if err != nil && err != nats.ErrStreamNotFound {
+	_, err := js.Stream(ctx, cfg.Name)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, jetstream.ErrStreamNotFound) {
 		return fmt.Errorf("error checking stream info: %w", err)
 	}
 
 	// If stream doesn't exist, create it
-	if stream == nil {
-		_, err = js.AddStream(&nats.StreamConfig{
-			Name:       cfg.Name,
-			Subjects:   cfg.Subjects,
-			Retention:  getRetentionPolicy(cfg.Retention),
-			Storage:    getStorageType(cfg.Storage),
-			MaxAge:     time.Duration(cfg.MaxAge) * time.Second,
-			Replicas:   1,
-			Discard:    nats.DiscardOld,
-			MaxMsgs:    -1,
-			MaxBytes:   -1,
-			Duplicates: time.Minute,
-		})
-		if err != nil {
-			return fmt.Errorf("error creating stream: %w", err)
-		}
+	_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:       cfg.Name,
+		Subjects:   cfg.Subjects,
+		Retention:  getRetentionPolicy(cfg.Retention),
+		Storage:    getStorageType(cfg.Storage),
+		MaxAge:     time.Duration(cfg.MaxAge) * time.Second,
+		Replicas:   1,
+		Discard:    jetstream.DiscardOld,
+		MaxMsgs:    -1,
+		MaxBytes:   -1,
+		Duplicates: time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating stream: %w", err)
+	}
+
+	return nil
+}
+
+// SetupObjectStore creates or updates the object store holding claim-checked
+// payloads, returning nil when no bucket is configured
+func SetupObjectStore(ctx context.Context, js jetstream.JetStream, cfg config.ClaimCheckConfig) (jetstream.ObjectStore, error) {
+	if cfg.Bucket == "" {
+		return nil, nil
 	}
+	store, err := js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:      cfg.Bucket,
+		Description: "Claim-checked message payloads",
+		TTL:         cfg.TTL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating object store: %w", err)
+	}
+	return store, nil
+}
 
+// Exists returns an error if the stream cannot be looked up
+func Exists(ctx context.Context, js jetstream.JetStream, name string) error {
+	if _, err := js.Stream(ctx, name); err != nil {
+		return fmt.Errorf("error checking stream %s: %w", name, err)
+	}
 	return nil
 }
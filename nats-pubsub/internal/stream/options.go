@@ -0,0 +1,96 @@
+package stream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fawadmazhar/nats-pubsub/internal/config"
+	"github.com/nats-io/nats.go"
+)
+
+// authOptions converts the auth configuration into NATS connection options
+func authOptions(cfg config.AuthConfig) ([]nats.Option, error) {
+	var opts []nats.Option
+
+	// Only one identity may be presented to the server
+	methods := 0
+	for _, set := range []bool{cfg.User != "", cfg.Token != "", cfg.NKeyFile != "", cfg.CredsFile != ""} {
+		if set {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return nil, errors.New("only one of user/password, token, nkey or creds file may be configured")
+	}
+
+	switch {
+	case cfg.User != "":
+		opts = append(opts, nats.UserInfo(cfg.User, cfg.Password))
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.NKeyFile != "":
+		opt, err := nats.NkeyOptionFromSeed(cfg.NKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	}
+
+	return opts, nil
+}
+
+// tlsConfig builds a TLS configuration from the CA, client certificate and server name settings
+func tlsConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("both TLS cert file and key file must be configured")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// connectOptions returns the TLS and authentication options for the given configuration
+func connectOptions(cfg config.NatsConfig) ([]nats.Option, error) {
+	opts, err := authOptions(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TLS.Enabled() {
+		tlsCfg, err := tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsCfg))
+	}
+
+	return opts, nil
+}
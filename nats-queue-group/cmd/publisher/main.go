@@ -1,22 +1,43 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/fawadmazhar/nats-queue-group/internal/config"
+	"github.com/fawadmazhar/nats-queue-group/internal/logging"
 	"github.com/fawadmazhar/nats-queue-group/internal/publisher"
+	"github.com/fawadmazhar/nats-queue-group/internal/tracing"
 )
 
 func main() {
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(config.LogLevel, config.LogFormat)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), config.TracingExporter, "nats-queue-group-publisher")
+	if err != nil {
+		logger.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+
 	// Create and start the publisher
-	p := publisher.NewPublisher()
-	
+	p := publisher.NewPublisher(logger)
+
 	// Start publisher in a goroutine
 	go func() {
 		if err := p.Run(); err != nil {
-			log.Fatalf("Publisher error: %v", err)
+			logger.Error("Publisher error", "error", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -24,8 +45,14 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
-	
-	log.Println("Publisher shutting down...")
+
+	logger.Info("Publisher shutting down")
 	p.Shutdown()
-}
 
+	// Flush any buffered spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Error flushing traces", "error", err)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/fawadmazhar/nats-queue-group/internal/config"
+	"github.com/fawadmazhar/nats-queue-group/internal/logging"
+	"github.com/fawadmazhar/nats-queue-group/internal/tracing"
 	"github.com/fawadmazhar/nats-queue-group/internal/worker"
 )
 
@@ -16,13 +22,28 @@ func main() {
 		workerID = os.Args[1]
 	}
 
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(config.LogLevel, config.LogFormat)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), config.TracingExporter, "nats-queue-group-worker")
+	if err != nil {
+		logger.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+
 	// Create and start the worker
-	w := worker.NewWorker(workerID)
-	
+	w := worker.NewWorker(workerID, logger)
+
 	// Start worker in a goroutine
 	go func() {
 		if err := w.Run(); err != nil {
-			log.Fatalf("Worker error: %v", err)
+			logger.Error("Worker error", "error", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -30,7 +51,14 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
-	
-	log.Printf("Worker %s shutting down...\n", workerID)
+
+	logger.Info("Worker shutting down", "worker_id", workerID)
 	w.Shutdown()
+
+	// Flush any buffered spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Error flushing traces", "error", err)
+	}
 }
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Environment variables holding the NATS credentials and TLS settings
+const (
+	EnvNatsUser          = "APP_NATS_USER"
+	EnvNatsPassword      = "APP_NATS_PASSWORD"
+	EnvNatsToken         = "APP_NATS_TOKEN"
+	EnvNatsNKeyFile      = "APP_NATS_NKEY_FILE"
+	EnvNatsCredsFile     = "APP_NATS_CREDS_FILE"
+	EnvNatsTLSCAFile     = "APP_NATS_TLS_CA_FILE"
+	EnvNatsTLSCertFile   = "APP_NATS_TLS_CERT_FILE"
+	EnvNatsTLSKeyFile    = "APP_NATS_TLS_KEY_FILE"
+	EnvNatsTLSServerName = "APP_NATS_TLS_SERVER_NAME"
+)
+
+// getEnv returns the value of the environment variable or the fallback if unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// NatsOptions returns the authentication and TLS options configured through the environment
+func NatsOptions() ([]nats.Option, error) {
+	var opts []nats.Option
+
+	user := os.Getenv(EnvNatsUser)
+	token := os.Getenv(EnvNatsToken)
+	nkeyFile := os.Getenv(EnvNatsNKeyFile)
+	credsFile := os.Getenv(EnvNatsCredsFile)
+
+	// Only one identity may be presented to the server
+	methods := 0
+	for _, value := range []string{user, token, nkeyFile, credsFile} {
+		if value != "" {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return nil, errors.New("only one of user/password, token, nkey or creds file may be configured")
+	}
+
+	switch {
+	case user != "":
+		opts = append(opts, nats.UserInfo(user, os.Getenv(EnvNatsPassword)))
+	case token != "":
+		opts = append(opts, nats.Token(token))
+	case nkeyFile != "":
+		opt, err := nats.NkeyOptionFromSeed(nkeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case credsFile != "":
+		opts = append(opts, nats.UserCredentials(credsFile))
+	}
+
+	tlsCfg, err := tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg != nil {
+		opts = append(opts, nats.Secure(tlsCfg))
+	}
+
+	return opts, nil
+}
+
+// tlsConfig builds a TLS configuration from the environment, or returns nil if TLS is not configured
+func tlsConfig() (*tls.Config, error) {
+	caFile := os.Getenv(EnvNatsTLSCAFile)
+	certFile := os.Getenv(EnvNatsTLSCertFile)
+	keyFile := os.Getenv(EnvNatsTLSKeyFile)
+	serverName := os.Getenv(EnvNatsTLSServerName)
+
+	if caFile == "" && certFile == "" && keyFile == "" && serverName == "" {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("both TLS cert file and key file must be configured")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
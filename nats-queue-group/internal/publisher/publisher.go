@@ -1,28 +1,40 @@
 package publisher
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"os"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/nats-io/nats.go"
 	"github.com/fawadmazhar/nats-queue-group/internal/config"
+	"github.com/fawadmazhar/nats-queue-group/internal/tracing"
+	"github.com/nats-io/nats.go"
 )
 
 type Publisher struct {
-	nc *nats.Conn
-	wg sync.WaitGroup
+	nc     *nats.Conn
+	wg     sync.WaitGroup
+	logger *slog.Logger
 }
 
-func NewPublisher() *Publisher {
-	nc, err := nats.Connect(config.DefaultNatsURL)
+func NewPublisher(logger *slog.Logger) *Publisher {
+	opts, err := config.NatsOptions()
+	if err != nil {
+		logger.Error("Error configuring NATS connection", "error", err)
+		os.Exit(1)
+	}
+
+	nc, err := nats.Connect(config.DefaultNatsURL, opts...)
 	if err != nil {
-		log.Fatalf("Error connecting to NATS: %v", err)
+		logger.Error("Error connecting to NATS", "error", err)
+		os.Exit(1)
 	}
 
 	return &Publisher{
-		nc: nc,
+		nc:     nc,
+		logger: logger.With("subject", config.Subject),
 	}
 }
 
@@ -31,10 +43,10 @@ func (p *Publisher) Run() error {
 
 	// Short delay to ensure workers have time to start and join the queue group
 	time.Sleep(500 * time.Millisecond)
-	
+
 	p.wg.Add(1)
 	go p.publishTasks()
-	
+
 	p.wg.Wait()
 	return nil
 }
@@ -45,30 +57,44 @@ func (p *Publisher) Shutdown() {
 
 func (p *Publisher) publishTasks() {
 	defer p.wg.Done()
-	
-	log.Println("Starting to publish tasks...")
-	
+
+	p.logger.Info("Starting to publish tasks", "count", config.NumTasks)
+
 	// Publish tasks
 	for i := 1; i <= config.NumTasks; i++ {
 		taskMsg := "Task " + strconv.Itoa(i)
-		
+
 		// Publish with request (expecting a response)
-		response, err := p.nc.Request(
-			config.Subject, 
-			[]byte(taskMsg), 
-			config.TaskResponseTimeout,
-		)
+		response, err := p.publishTask(taskMsg)
 		if err != nil {
-			log.Printf("Error publishing task %d: %v\n", i, err)
+			p.logger.Error("Error publishing task", "task", i, "error", err)
 			continue
 		}
-		
-		log.Printf("Published: %s, Response: %s\n", taskMsg, string(response.Data))
-		
+
+		p.logger.Info("Published task", "task", taskMsg, "response", string(response.Data))
+
 		// Wait between tasks to allow better distribution
 		time.Sleep(config.TaskPublishInterval)
 	}
-	
-	log.Println("Finished publishing tasks")
+
+	p.logger.Info("Finished publishing tasks")
 }
 
+// publishTask sends a task as a request within a span propagated in the message headers
+func (p *Publisher) publishTask(task string) (*nats.Msg, error) {
+	msg := nats.NewMsg(config.Subject)
+	msg.Data = []byte(task)
+
+	ctx, span := tracing.StartProducerSpan(context.Background(), msg)
+	defer span.End()
+
+	reqCtx, cancel := context.WithTimeout(ctx, config.TaskResponseTimeout)
+	defer cancel()
+
+	response, err := p.nc.RequestMsgWithContext(reqCtx, msg)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	return response, nil
+}
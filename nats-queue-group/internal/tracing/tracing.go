@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/fawadmazhar/nats-queue-group/internal/tracing"
+
+// Supported span exporters
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Setup installs the global W3C trace context propagator and a tracer provider
+// exporting spans with the given exporter. The OTLP exporter is configured
+// through the standard OTEL_EXPORTER_OTLP_* environment variables. The returned
+// function flushes and stops the tracer provider.
+func Setup(ctx context.Context, exporter, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(exporter) {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s trace exporter: %w", exporter, err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// HeaderCarrier adapts NATS message headers to the OpenTelemetry TextMapCarrier interface
+type HeaderCarrier nats.Header
+
+// Get returns the first value associated with the key
+func (c HeaderCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+// Set stores the key-value pair
+func (c HeaderCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+// Keys lists the keys stored in the carrier
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// messagingAttributes returns the semantic convention attributes describing a NATS message
+func messagingAttributes(operation attribute.KeyValue, subject string, header nats.Header) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("nats"),
+		operation,
+		semconv.MessagingDestinationName(subject),
+	}
+	if id := header.Get(nats.MsgIdHdr); id != "" {
+		attrs = append(attrs, semconv.MessagingMessageID(id))
+	}
+	return attrs
+}
+
+// StartProducerSpan starts a publish span for the message and injects the
+// trace context into its headers
+func StartProducerSpan(ctx context.Context, msg *nats.Msg, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	attrs = append(messagingAttributes(semconv.MessagingOperationTypePublish, msg.Subject, msg.Header), attrs...)
+	attrs = append(attrs, semconv.MessagingMessageBodySize(len(msg.Data)))
+	ctx, span := otel.Tracer(tracerName).Start(ctx, msg.Subject+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attrs...),
+	)
+
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier(msg.Header))
+	return ctx, span
+}
+
+// StartConsumerSpan extracts the trace context from the message headers and
+// starts a process span as its child
+func StartConsumerSpan(ctx context.Context, subject string, header nats.Header, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if header != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier(header))
+	}
+
+	attrs = append(messagingAttributes(semconv.MessagingOperationTypeDeliver, subject, header), attrs...)
+	return otel.Tracer(tracerName).Start(ctx, subject+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// Inject writes the trace context of ctx into the headers, e.g. of a reply
+func Inject(ctx context.Context, header nats.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier(header))
+}
+
+// RecordError marks the span as failed with the given error
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
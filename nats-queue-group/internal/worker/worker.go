@@ -1,32 +1,45 @@
 package worker
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"os"
 	"sync"
 	"time"
 
-	"github.com/nats-io/nats.go"
 	"github.com/fawadmazhar/nats-queue-group/internal/config"
+	"github.com/fawadmazhar/nats-queue-group/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Worker struct {
-	id string
-	nc *nats.Conn
-	sub *nats.Subscription
-	wg  sync.WaitGroup
+	id     string
+	nc     *nats.Conn
+	sub    *nats.Subscription
+	wg     sync.WaitGroup
+	logger *slog.Logger
 }
 
-func NewWorker(id string) *Worker {
-	nc, err := nats.Connect(config.DefaultNatsURL)
+func NewWorker(id string, logger *slog.Logger) *Worker {
+	opts, err := config.NatsOptions()
+	if err != nil {
+		logger.Error("Error configuring NATS connection", "error", err)
+		os.Exit(1)
+	}
+
+	nc, err := nats.Connect(config.DefaultNatsURL, opts...)
 	if err != nil {
-		log.Fatalf("Error connecting to NATS: %v", err)
+		logger.Error("Error connecting to NATS", "error", err)
+		os.Exit(1)
 	}
 
 	return &Worker{
-		id: id,
-		nc: nc,
+		id:     id,
+		nc:     nc,
+		logger: logger.With("worker_id", id, "subject", config.Subject, "queue_group", config.QueueGroup),
 	}
 }
 
@@ -43,12 +56,10 @@ func (w *Worker) Run() error {
 	}
 	w.sub = sub
 
-	log.Printf("Worker %s started and joined '%s' queue group\n", w.id, config.QueueGroup)
+	w.logger.Info("Worker started and joined queue group")
 
 	// Wait indefinitely (until shutdown is called)
 	select {}
-	
-	return nil
 }
 
 func (w *Worker) Shutdown() {
@@ -58,16 +69,32 @@ func (w *Worker) Shutdown() {
 }
 
 func (w *Worker) processTask(msg *nats.Msg) {
+	// Continue the trace started by the publisher
+	ctx, span := tracing.StartConsumerSpan(context.Background(), msg.Subject, msg.Header,
+		attribute.String("messaging.nats.queue_group", config.QueueGroup),
+		attribute.String("worker.id", w.id),
+	)
+	defer span.End()
+
 	// Simulate processing time
 	processingTime := rand.Intn(
 		int(config.TaskProcessingMaxTime-config.TaskProcessingMinTime),
 	) + int(config.TaskProcessingMinTime)
 	time.Sleep(time.Duration(processingTime))
-	
-	log.Printf("Worker %s processed message: %s (took %dms)\n", 
-		w.id, string(msg.Data), processingTime/int(time.Millisecond))
-	
-	// Send an acknowledgment
-	msg.Respond([]byte("Processed by worker " + w.id))
-}
 
+	w.logger.Info("Worker processed message",
+		"data", string(msg.Data),
+		"duration_ms", processingTime/int(time.Millisecond),
+	)
+
+	// Send an acknowledgment, propagating the trace context back to the publisher
+	reply := &nats.Msg{
+		Data:   []byte("Processed by worker " + w.id),
+		Header: nats.Header{},
+	}
+	tracing.Inject(ctx, reply.Header)
+	if err := msg.RespondMsg(reply); err != nil {
+		tracing.RecordError(span, err)
+		w.logger.Error("Error responding to task", "error", err)
+	}
+}
@@ -3,20 +3,48 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
+	"os"
 	"time"
 
+	"github.com/fawadmazhar/nats-reqrep/internal/config"
+	"github.com/fawadmazhar/nats-reqrep/internal/logging"
 	"github.com/fawadmazhar/nats-reqrep/internal/reqrep"
+	"github.com/fawadmazhar/nats-reqrep/internal/tracing"
 )
 
 func main() {
-	client := reqrep.NewClient()
-	
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(config.LogLevel, config.LogFormat)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), config.TracingExporter, "nats-reqrep-client")
+	if err != nil {
+		logger.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+
+	client := reqrep.NewClient(logger)
+
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := client.SendRequest(ctx, "Hello from client!")
+	err = client.SendRequest(ctx, "Hello from client!")
+
+	// Flush any buffered spans before exiting
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("Error flushing traces", "error", err)
+	}
+
 	if err != nil {
-		log.Fatalf("Client error: %v", err)
+		logger.Error("Client error", "error", err)
+		os.Exit(1)
 	}
 }
@@ -1,30 +1,58 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/fawadmazhar/nats-reqrep/internal/config"
+	"github.com/fawadmazhar/nats-reqrep/internal/logging"
 	"github.com/fawadmazhar/nats-reqrep/internal/reqrep"
+	"github.com/fawadmazhar/nats-reqrep/internal/tracing"
 )
 
 func main() {
-	server := reqrep.NewServer()
+	// Create the logger and route the standard library logger through it
+	logger, err := logging.New(config.LogLevel, config.LogFormat)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces if configured
+	shutdownTracing, err := tracing.Setup(context.Background(), config.TracingExporter, "nats-reqrep-server")
+	if err != nil {
+		logger.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+
+	server := reqrep.NewServer(logger)
 
 	// Start server in a goroutine
 	go func() {
 		if err := server.Run(); err != nil {
-			log.Fatalf("Server error: %v", err)
+			logger.Error("Server error", "error", err)
+			os.Exit(1)
 		}
 	}()
 
-	log.Println("Server is running. Waiting for requests...")
+	logger.Info("Server is running, waiting for requests")
 
 	// Wait for interrupt signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
-	
-	log.Println("Server shutting down...")
+
+	logger.Info("Server shutting down")
+
+	// Flush any buffered spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Error flushing traces", "error", err)
+	}
 }
@@ -9,5 +9,12 @@ const (
 )
 
 var (
-	DefaultNatsURL = nats.DefaultURL
+	DefaultNatsURL = getEnv("APP_NATS_URL", nats.DefaultURL)
+
+	// TracingExporter selects the span exporter: none, stdout or otlp
+	TracingExporter = getEnv("APP_TRACING_EXPORTER", "none")
+
+	// Logging configuration
+	LogLevel  = getEnv("APP_LOG_LEVEL", "info")  // debug, info, warn or error
+	LogFormat = getEnv("APP_LOG_FORMAT", "text") // text or json
 )
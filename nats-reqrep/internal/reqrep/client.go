@@ -3,33 +3,45 @@ package reqrep
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/fawadmazhar/nats-reqrep/internal/config"
+	"github.com/fawadmazhar/nats-reqrep/internal/tracing"
 )
 
 type Client struct {
-	nc *nats.Conn
-	js nats.JetStreamContext
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	logger *slog.Logger
 }
 
-func NewClient() *Client {
-	nc, err := nats.Connect(config.DefaultNatsURL)
+func NewClient(logger *slog.Logger) *Client {
+	opts, err := config.NatsOptions()
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		logger.Error("Invalid NATS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	nc, err := nats.Connect(config.DefaultNatsURL, opts...)
+	if err != nil {
+		logger.Error("Failed to connect to NATS", "error", err)
+		os.Exit(1)
 	}
 
 	js, err := nc.JetStream()
 	if err != nil {
 		nc.Close()
-		log.Fatalf("Failed to get JetStream context: %v", err)
+		logger.Error("Failed to get JetStream context", "error", err)
+		os.Exit(1)
 	}
 
 	return &Client{
-		nc: nc,
-		js: js,
+		nc:     nc,
+		js:     js,
+		logger: logger,
 	}
 }
 
@@ -39,12 +51,19 @@ func (c *Client) SendRequest(ctx context.Context, data string) error {
 	// Create unique subject for this request
 	requestSubject := fmt.Sprintf("%s.%d", config.Subject, time.Now().UnixNano())
 	
+	// Start a span for the request and propagate it in the message headers
+	req := nats.NewMsg(requestSubject)
+	req.Data = []byte(data)
+	ctx, span := tracing.StartProducerSpan(ctx, req)
+	defer span.End()
+
 	// Send request using core NATS request-reply
-	msg, err := c.nc.RequestWithContext(ctx, requestSubject, []byte(data))
+	msg, err := c.nc.RequestMsgWithContext(ctx, req)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return fmt.Errorf("failed to receive response: %w", err)
 	}
 
-	log.Printf("Received response: %s", string(msg.Data))
+	c.logger.Info("Received response", "subject", requestSubject, "response", string(msg.Data))
 	return nil
 }
@@ -1,34 +1,47 @@
 package reqrep
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/fawadmazhar/nats-reqrep/internal/config"
+	"github.com/fawadmazhar/nats-reqrep/internal/tracing"
 )
 
 type Server struct {
-	nc *nats.Conn
-	js nats.JetStreamContext
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	logger *slog.Logger
 }
 
-func NewServer() *Server {
-	nc, err := nats.Connect(config.DefaultNatsURL)
+func NewServer(logger *slog.Logger) *Server {
+	opts, err := config.NatsOptions()
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		logger.Error("Invalid NATS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	nc, err := nats.Connect(config.DefaultNatsURL, opts...)
+	if err != nil {
+		logger.Error("Failed to connect to NATS", "error", err)
+		os.Exit(1)
 	}
 
 	js, err := nc.JetStream()
 	if err != nil {
 		nc.Close()
-		log.Fatalf("Failed to get JetStream context: %v", err)
+		logger.Error("Failed to get JetStream context", "error", err)
+		os.Exit(1)
 	}
 
 	return &Server{
-		nc: nc,
-		js: js,
+		nc:     nc,
+		js:     js,
+		logger: logger.With("stream", config.StreamName, "consumer", config.ConsumerName),
 	}
 }
 
@@ -68,27 +81,46 @@ func (s *Server) Run() error {
 			if err == nats.ErrTimeout {
 				continue
 			}
-			log.Printf("Error fetching messages: %v", err)
+			s.logger.Error("Error fetching messages", "error", err)
 			continue
 		}
 
 		for _, msg := range msgs {
-			// Process request
-			log.Printf("Received request: %s", string(msg.Data))
-
-			// Create response
-			response := fmt.Sprintf("Response to: %s", string(msg.Data))
-
-			// Reply to the message
-			err = msg.Respond([]byte(response))
-			if err != nil {
-				log.Printf("Error responding to message: %v", err)
-			} else {
-				log.Printf("Sent response: %s", response)
-			}
-
-			// Acknowledge message
-			msg.Ack()
+			s.handleRequest(msg)
 		}
 	}
 }
+
+// handleRequest replies to a single request within a span continuing the client's trace
+func (s *Server) handleRequest(msg *nats.Msg) {
+	ctx, span := tracing.StartConsumerSpan(context.Background(), msg.Subject, msg.Header)
+	defer span.End()
+
+	logger := s.logger.With("subject", msg.Subject)
+	if meta, err := msg.Metadata(); err == nil {
+		logger = logger.With("seq", meta.Sequence.Stream, "delivery_count", meta.NumDelivered)
+	}
+
+	// Process request
+	logger.Info("Received request", "data", string(msg.Data))
+
+	// Create response, propagating the trace context back to the client
+	response := fmt.Sprintf("Response to: %s", string(msg.Data))
+	reply := &nats.Msg{
+		Data:   []byte(response),
+		Header: nats.Header{},
+	}
+	tracing.Inject(ctx, reply.Header)
+
+	// Reply to the message
+	err := msg.RespondMsg(reply)
+	if err != nil {
+		tracing.RecordError(span, err)
+		logger.Error("Error responding to message", "error", err)
+	} else {
+		logger.Info("Sent response", "response", response)
+	}
+
+	// Acknowledge message
+	msg.Ack()
+}